@@ -178,6 +178,7 @@ type BuildController struct {
 	imageConfigStoreSynced                cache.InformerSynced
 	podStoreSynced                        cache.InformerSynced
 	secretStoreSynced                     cache.InformerSynced
+	configMapStoreSynced                  cache.InformerSynced
 	serviceAccountStoreSynced             cache.InformerSynced
 	imageStreamStoreSynced                cache.InformerSynced
 	openshiftConfigConfigMapStoreSynced   cache.InformerSynced
@@ -192,6 +193,8 @@ type BuildController struct {
 	internalRegistryHostname string
 	buildCSIVolumesEnabled   bool
 
+	annotateEffectiveNodeSelector bool
+
 	recorder                record.EventRecorder
 	registryConfData        string
 	signaturePolicyData     string
@@ -223,6 +226,9 @@ type BuildControllerParams struct {
 	BuildDefaults                      builddefaults.BuildDefaults
 	BuildOverrides                     buildoverrides.BuildOverrides
 	InternalRegistryHostname           string
+	// AnnotateEffectiveNodeSelector records the node selector of build pods, after
+	// build defaults and overrides are applied, in a pod annotation for debugging.
+	AnnotateEffectiveNodeSelector bool
 }
 
 // NewBuildController creates a new BuildController.
@@ -265,6 +271,8 @@ func NewBuildController(params *BuildControllerParams) *BuildController {
 		buildOverrides:           params.BuildOverrides,
 		internalRegistryHostname: params.InternalRegistryHostname,
 
+		annotateEffectiveNodeSelector: params.AnnotateEffectiveNodeSelector,
+
 		buildQueue:            workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "build"),
 		imageStreamQueue:      newResourceTriggerQueue(),
 		buildConfigQueue:      workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "build-completed"),
@@ -318,6 +326,7 @@ func NewBuildController(params *BuildControllerParams) *BuildController {
 	c.proxyCfgStoreSynced = c.proxyCfgInformer.HasSynced
 	c.imageContentSourcePolicySynched = c.imageContentSourcePolicyInformer.HasSynced
 	c.secretStoreSynced = params.SecretInformer.Informer().HasSynced
+	c.configMapStoreSynced = params.ConfigMapInformer.Informer().HasSynced
 	c.serviceAccountStoreSynced = params.ServiceAccountInformer.Informer().HasSynced
 	c.imageStreamStoreSynced = params.ImageStreamInformer.Informer().HasSynced
 	c.buildControllerConfigStoreSynced = params.BuildControllerConfigInformer.Informer().HasSynced
@@ -452,6 +461,7 @@ func (bc *BuildController) Run(workers int, stopCh <-chan struct{}) {
 		bc.buildStoreSynced,
 		bc.podStoreSynced,
 		bc.secretStoreSynced,
+		bc.configMapStoreSynced,
 		bc.serviceAccountStoreSynced,
 		bc.imageStreamStoreSynced,
 		bc.openshiftConfigConfigMapStoreSynced,
@@ -685,7 +695,8 @@ func (bc *BuildController) cancelBuild(build *buildv1.Build) (*buildUpdate, erro
 func (bc *BuildController) handleNewBuild(build *buildv1.Build, pod *corev1.Pod) (*buildUpdate, error) {
 	if pod != nil {
 		// We're in phase New and a build pod already exists.  If the pod has an
-		// owner reference to the build, we take that to mean that we created
+		// owner reference to the build, or is a detached pod annotated with the
+		// UID of the build, we take that to mean that we created
 		// the pod but failed to update the build object afterwards.  In
 		// principle, we should re-run all the handleNewBuild/createBuildPod
 		// logic in this case.  At the moment, however, we short-cut straight to
@@ -702,7 +713,7 @@ func (bc *BuildController) handleNewBuild(build *buildv1.Build, pod *corev1.Pod)
 		// the build FSM: New -> X -> Pending -> Running, where all the pre-work
 		// is done in the transition New->X, and nothing more than the build pod
 		// creation is done in the transition X->Pending.
-		if strategy.HasOwnerReference(pod, build) {
+		if strategy.IsPodOfBuild(pod, build) {
 			return bc.handleActiveBuild(build, pod)
 		} else {
 			err := retryOnOwnerRef(build, pod)
@@ -755,11 +766,22 @@ func (bc *BuildController) createPodSpec(build *buildv1.Build, caData map[string
 	if err := bc.buildOverrides.ApplyOverrides(podSpec); err != nil {
 		return nil, fmt.Errorf("failed to apply build overrides for build %s/%s: %v", build.Namespace, build.Name, err)
 	}
+	if bc.annotateEffectiveNodeSelector {
+		metav1.SetMetaDataAnnotation(&podSpec.ObjectMeta, strategy.EffectiveNodeSelectorAnnotation, labels.Set(podSpec.Spec.NodeSelector).String())
+	}
 
 	// Handle resolving ValueFrom references in build environment variables
 	if err := common.ResolveValueFrom(podSpec, bc.kubeClient); err != nil {
 		return nil, err
 	}
+	if finalizer, ok := bc.createStrategy.(buildPodFinalizer); ok {
+		if err := finalizer.FinalizeBuildPod(build, podSpec); err != nil {
+			if strategy.IsFatal(err) {
+				return nil, &strategy.FatalError{Reason: fmt.Sprintf("failed to finalize the build pod for build %s/%s: %v", build.Namespace, build.Name, err)}
+			}
+			return nil, fmt.Errorf("failed to finalize the build pod for build %s/%s: %v", build.Namespace, build.Name, err)
+		}
+	}
 	return podSpec, nil
 }
 
@@ -1278,7 +1300,7 @@ func (bc *BuildController) createBuildPod(build *buildv1.Build) (*buildUpdate, e
 		// HashOwnerReference does not distinguish between having no refs to compare with the build and
 		// having a different ref compared to our build here;  that said, if the pod has not owner refs,
 		// there is no chance GC will clean it up, so bypass our retry here
-		if !strategy.HasOwnerReference(existingPod, build) {
+		if !strategy.IsPodOfBuild(existingPod, build) {
 			err := retryOnOwnerRef(build, existingPod)
 			if err != nil {
 				return nil, err
@@ -1434,7 +1456,12 @@ func (bc *BuildController) handleActiveBuild(build *buildv1.Build, pod *corev1.P
 			}
 		}
 	case corev1.PodFailed:
-		if isOOMKilled(pod) {
+		if deadlineWatcherFailedAfterBuild(pod) {
+			klog.V(2).Infof("Ignoring the failed deadline watcher of build %s as its other containers succeeded", buildDesc(build))
+			if build.Status.Phase != buildv1.BuildPhaseComplete {
+				update = transitionToPhase(buildv1.BuildPhaseComplete, "", "")
+			}
+		} else if isOOMKilled(pod) {
 			update = transitionToPhase(buildv1.BuildPhaseFailed, buildv1.StatusReasonOutOfMemoryKilled, "The build pod was killed due to an out of memory condition.")
 		} else if isPodEvicted(pod) {
 			// Use the pod status message to report why the build pod was evicted.
@@ -1452,6 +1479,27 @@ func (bc *BuildController) handleActiveBuild(build *buildv1.Build, pod *corev1.P
 	return update, nil
 }
 
+// deadlineWatcherFailedAfterBuild returns true if the pod failed only because its
+// deadline watcher sidecar exited with an error, while every other container
+// terminated successfully.
+func deadlineWatcherFailedAfterBuild(pod *corev1.Pod) bool {
+	watcherFailed := false
+	for _, c := range pod.Status.ContainerStatuses {
+		terminated := c.State.Terminated
+		if terminated == nil {
+			return false
+		}
+		if c.Name == strategy.DeadlineWatcherContainer {
+			watcherFailed = terminated.ExitCode != 0
+			continue
+		}
+		if terminated.ExitCode != 0 {
+			return false
+		}
+	}
+	return watcherFailed
+}
+
 func isOOMKilled(pod *corev1.Pod) bool {
 	if pod == nil {
 		return false
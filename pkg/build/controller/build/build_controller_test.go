@@ -2,6 +2,7 @@ package build
 
 import (
 	"context"
+	"crypto/sha256"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -37,6 +38,7 @@ import (
 	buildv1 "github.com/openshift/api/build/v1"
 	configv1 "github.com/openshift/api/config/v1"
 	imagev1 "github.com/openshift/api/image/v1"
+	openshiftcontrolplanev1 "github.com/openshift/api/openshiftcontrolplane/v1"
 	buildv1client "github.com/openshift/client-go/build/clientset/versioned"
 	fakebuildv1client "github.com/openshift/client-go/build/clientset/versioned/fake"
 	buildv1informer "github.com/openshift/client-go/build/informers/externalversions"
@@ -148,6 +150,31 @@ func TestHandleBuild(t *testing.T) {
 		pod.Status.ContainerStatuses[0].State.Terminated.Message = "termination message"
 		return pod
 	}
+	withDeadlineWatcher := func(pod *corev1.Pod, builderExitCode int32) *corev1.Pod {
+		pod.Status.ContainerStatuses = []corev1.ContainerStatus{
+			{
+				Name: "container",
+				State: corev1.ContainerState{
+					Terminated: &corev1.ContainerStateTerminated{ExitCode: builderExitCode},
+				},
+			},
+			{
+				Name: strategy.DeadlineWatcherContainer,
+				State: corev1.ContainerState{
+					Terminated: &corev1.ContainerStateTerminated{ExitCode: 143},
+				},
+			},
+		}
+		return pod
+	}
+	withUID := func(build *buildv1.Build) *buildv1.Build {
+		build.UID = "detached-build-uid"
+		return build
+	}
+	withDetachedBuildUID := func(pod *corev1.Pod) *corev1.Pod {
+		pod.Annotations[strategy.DetachedBuildUIDAnnotation] = "detached-build-uid"
+		return pod
+	}
 	withOwnerReference := func(pod *corev1.Pod, build *buildv1.Build) *corev1.Pod {
 		t := true
 		pod.OwnerReferences = []metav1.OwnerReference{{
@@ -240,6 +267,18 @@ func TestHandleBuild(t *testing.T) {
 				podNameAnnotation(pod(corev1.PodRunning).Name).
 				update,
 		},
+		{
+			name:  "new with existing detached pod",
+			build: withUID(build(buildv1.BuildPhaseNew)),
+			pod:   withDetachedBuildUID(pod(corev1.PodRunning)),
+			expectUpdate: newUpdate().
+				phase(buildv1.BuildPhaseRunning).
+				reason("").
+				message("").
+				startTime(now).
+				podNameAnnotation(pod(corev1.PodRunning).Name).
+				update,
+		},
 		{
 			name:  "new with existing unrelated pod",
 			build: build(buildv1.BuildPhaseNew),
@@ -317,6 +356,30 @@ func TestHandleBuild(t *testing.T) {
 				completionTime(now).
 				update,
 		},
+		{
+			name:  "running -> complete with a failed deadline watcher",
+			build: build(buildv1.BuildPhaseRunning),
+			pod:   withDeadlineWatcher(pod(corev1.PodFailed), 0),
+			expectUpdate: newUpdate().
+				phase(buildv1.BuildPhaseComplete).
+				reason("").
+				message("").
+				startTime(now).
+				completionTime(now).
+				update,
+		},
+		{
+			name:  "running -> failed with a failed builder and deadline watcher",
+			build: build(buildv1.BuildPhaseRunning),
+			pod:   withDeadlineWatcher(pod(corev1.PodFailed), 1),
+			expectUpdate: newUpdate().
+				phase(buildv1.BuildPhaseFailed).
+				reason(buildv1.StatusReasonGenericBuildFailed).
+				message("Generic Build failure - check logs for details.").
+				startTime(now).
+				completionTime(now).
+				update,
+		},
 		{
 			name:  "running -> complete no BC",
 			build: build(buildv1.BuildPhaseRunning),
@@ -687,6 +750,74 @@ func (*errorStrategy) CreateBuildPod(build *buildv1.Build, additionalCAs map[str
 	return nil, fmt.Errorf("error")
 }
 
+func TestCreateBuildPodEffectiveNodeSelectorAnnotation(t *testing.T) {
+	tests := []struct {
+		name         string
+		nodeSelector buildv1.OptionalNodeSelector
+		expected     string
+	}{
+		{
+			name:     "default node selector",
+			expected: "kubernetes.io/os=linux,node-role.kubernetes.io/builder=",
+		},
+		{
+			name:         "build node selector",
+			nodeSelector: buildv1.OptionalNodeSelector{"disktype": "ssd"},
+			expected:     "disktype=ssd,kubernetes.io/os=linux",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			bc := newFakeBuildController(nil, nil, nil, nil, nil)
+			defer bc.stop()
+			bc.annotateEffectiveNodeSelector = true
+			bc.buildDefaults.Config = &openshiftcontrolplanev1.BuildDefaultsConfig{
+				NodeSelector: map[string]string{"node-role.kubernetes.io/builder": ""},
+			}
+			build := dockerStrategy(mockBuild(buildv1.BuildPhaseNew, buildv1.BuildOutput{}))
+			build.Spec.NodeSelector = tc.nodeSelector
+
+			pod, err := bc.createPodSpec(build, nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if actual := pod.Annotations[strategy.EffectiveNodeSelectorAnnotation]; actual != tc.expected {
+				t.Errorf("expected effective node selector %q, got %q", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestCreateBuildPodBuildChecksumAnnotation(t *testing.T) {
+	bc := newFakeBuildController(nil, nil, nil, nil, nil)
+	defer bc.stop()
+	bc.createStrategy = &typeBasedFactoryStrategy{
+		customBuildStrategy: &strategy.CustomBuildStrategy{AnnotateBuildChecksum: true},
+	}
+	bc.buildDefaults.Config = &openshiftcontrolplanev1.BuildDefaultsConfig{
+		Env: []corev1.EnvVar{{Name: "DEFAULTED", Value: "true"}},
+	}
+	build := customStrategy(mockBuild(buildv1.BuildPhaseNew, buildv1.BuildOutput{}))
+
+	pod, err := bc.createPodSpec(build, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var data string
+	for _, env := range pod.Spec.Containers[0].Env {
+		if env.Name == "BUILD" {
+			data = env.Value
+		}
+	}
+	if !strings.Contains(data, "DEFAULTED") {
+		t.Fatalf("expected the build defaults in the BUILD environment variable, got %s", data)
+	}
+	expected := fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(data)))
+	if actual := pod.Annotations[strategy.BuildChecksumAnnotation]; actual != expected {
+		t.Errorf("expected checksum annotation %q of the final BUILD value, got %q", expected, actual)
+	}
+}
+
 func TestCreateBuildPodWithPodSpecCreationError(t *testing.T) {
 	bc := newFakeBuildController(nil, nil, nil, nil, nil)
 	defer bc.stop()
@@ -2035,6 +2166,15 @@ func dockerStrategy(build *buildv1.Build) *buildv1.Build {
 	return build
 }
 
+func customStrategy(build *buildv1.Build) *buildv1.Build {
+	build.Spec.Strategy = buildv1.BuildStrategy{
+		CustomStrategy: &buildv1.CustomBuildStrategy{
+			From: corev1.ObjectReference{Kind: "DockerImage", Name: "builder-image"},
+		},
+	}
+	return build
+}
+
 func pipelineStrategy(build *buildv1.Build) *buildv1.Build {
 	build.Spec.Strategy = buildv1.BuildStrategy{
 		JenkinsPipelineStrategy: &buildv1.JenkinsPipelineBuildStrategy{},
@@ -15,6 +15,14 @@ import (
 	"github.com/openshift/openshift-controller-manager/pkg/build/controller/common"
 )
 
+// reservedAnnotationPrefixes are annotations managed by the build controller, which
+// must not be set through default annotations. Other annotations the build pod
+// already has are never overwritten by the defaults.
+var reservedAnnotationPrefixes = []string{
+	"openshift.io/build.",
+	"build.openshift.io/",
+}
+
 type BuildDefaults struct {
 	Config       *openshiftcontrolplanev1.BuildDefaultsConfig
 	DefaultProxy *configv1.ProxySpec
@@ -136,6 +144,10 @@ func (b BuildDefaults) applyPodDefaults(pod *corev1.Pod, isCustomBuild bool) {
 			pod.Annotations = map[string]string{}
 		}
 		for k, v := range b.Config.Annotations {
+			if isReservedAnnotation(k) {
+				klog.V(2).Infof("Ignoring reserved default annotation %s for pod %s/%s", k, pod.Namespace, pod.Name)
+				continue
+			}
 			addDefaultAnnotation(k, v, pod.Annotations)
 		}
 	}
@@ -284,6 +296,15 @@ func addDefaultNodeSelector(k, v string, selectors map[string]string) {
 	}
 }
 
+func isReservedAnnotation(k string) bool {
+	for _, prefix := range reservedAnnotationPrefixes {
+		if strings.HasPrefix(strings.TrimSpace(k), prefix) {
+			return true
+		}
+	}
+	return false
+}
+
 func addDefaultAnnotation(k, v string, annotations map[string]string) {
 	if _, ok := annotations[k]; !ok {
 		annotations[k] = v
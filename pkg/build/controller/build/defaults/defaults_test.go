@@ -592,6 +592,18 @@ func TestBuildDefaultsAnnotations(t *testing.T) {
 			defaults:    map[string]string{"key1": "default1", "key2": "default2"},
 			expected:    map[string]string{"key1": "value1", "key2": "default2"},
 		},
+		{
+			name:        "build - reserved annotations",
+			build:       testutil.Build().AsBuild(),
+			annotations: map[string]string{"key1": "value1"},
+			defaults: map[string]string{
+				"key2":                         "default2",
+				buildv1.BuildAnnotation:        "default-build",
+				"io.kubernetes.cri-o.Devices":  "/dev/kvm:rwm",
+				"build.openshift.io/something": "default",
+			},
+			expected: map[string]string{"key1": "value1", "key2": "default2", "io.kubernetes.cri-o.Devices": "/dev/kvm:rwm"},
+		},
 	}
 
 	for _, test := range tests {
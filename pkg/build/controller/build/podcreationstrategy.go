@@ -14,6 +14,13 @@ type buildPodCreationStrategy interface {
 	CreateBuildPod(build *buildv1.Build, additionalCAs map[string]string, internalRegistryHost string) (*corev1.Pod, error)
 }
 
+// buildPodFinalizer is implemented by build strategies which update the build pod
+// once build defaults and overrides are applied to it and the build environment is
+// resolved.
+type buildPodFinalizer interface {
+	FinalizeBuildPod(build *buildv1.Build, pod *corev1.Pod) error
+}
+
 type typeBasedFactoryStrategy struct {
 	dockerBuildStrategy buildPodCreationStrategy
 	sourceBuildStrategy buildPodCreationStrategy
@@ -49,3 +56,21 @@ func (f *typeBasedFactoryStrategy) CreateBuildPod(build *buildv1.Build, addition
 	}
 	return pod, err
 }
+
+// FinalizeBuildPod finalizes the pod with the strategy of the build, if it updates
+// its build pods once they are complete.
+func (f *typeBasedFactoryStrategy) FinalizeBuildPod(build *buildv1.Build, pod *corev1.Pod) error {
+	var s buildPodCreationStrategy
+	switch {
+	case build.Spec.Strategy.DockerStrategy != nil:
+		s = f.dockerBuildStrategy
+	case build.Spec.Strategy.SourceStrategy != nil:
+		s = f.sourceBuildStrategy
+	case build.Spec.Strategy.CustomStrategy != nil:
+		s = f.customBuildStrategy
+	}
+	if finalizer, ok := s.(buildPodFinalizer); ok {
+		return finalizer.FinalizeBuildPod(build, pod)
+	}
+	return nil
+}
@@ -1,8 +1,12 @@
 package strategy
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"reflect"
+	"strings"
+	"time"
 
 	"k8s.io/klog/v2"
 
@@ -12,6 +16,8 @@ import (
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	v1lister "k8s.io/client-go/listers/core/v1"
+	"k8s.io/utils/clock"
 
 	buildv1 "github.com/openshift/api/build/v1"
 	buildutil "github.com/openshift/openshift-controller-manager/pkg/build/buildutil"
@@ -20,16 +26,363 @@ import (
 var (
 	customBuildEncodingScheme       = runtime.NewScheme()
 	customBuildEncodingCodecFactory = serializer.NewCodecFactory(customBuildEncodingScheme)
+	customBuildStrictCodecFactory   = serializer.NewCodecFactory(customBuildEncodingScheme, serializer.EnableStrict)
 )
 
 func init() {
 	utilruntime.Must(buildv1.Install(customBuildEncodingScheme))
 	utilruntime.Must(buildv1.DeprecatedInstallWithoutGroup(customBuildEncodingScheme))
 	customBuildEncodingCodecFactory = serializer.NewCodecFactory(customBuildEncodingScheme)
+	customBuildStrictCodecFactory = serializer.NewCodecFactory(customBuildEncodingScheme, serializer.EnableStrict)
+}
+
+// ImageResolver resolves image references to the digest they point to.
+type ImageResolver interface {
+	// ResolveImageDigest returns the digest the image pulled by pods in the given
+	// namespace resolves to, or an empty string if it cannot be resolved.
+	ResolveImageDigest(namespace, image string) (string, error)
+}
+
+// ImageMetadataResolver resolves the metadata of images.
+type ImageMetadataResolver interface {
+	// ResolveImageArchitectures returns the architectures the image pulled by pods
+	// in the given namespace is available for, or nil if they are not known.
+	ResolveImageArchitectures(namespace, image string) ([]string, error)
 }
 
 // CustomBuildStrategy creates a build using a custom builder image.
 type CustomBuildStrategy struct {
+	// CriticalBuildAnnotations are stamped on the pods of builds that opt in by
+	// setting the openshift.io/build.critical annotation to "true", so that
+	// PodDisruptionBudgets or node maintenance controllers leave them alone.
+	CriticalBuildAnnotations map[string]string
+
+	// ManifestAnnotations are passed to the builder in the BUILD_MANIFEST_ANNOTATIONS
+	// environment variable, to be set as annotations on the output image manifest.
+	ManifestAnnotations []buildv1.ImageLabel
+
+	// DefaultTokenAudience, when set, projects a service account token with this
+	// audience into the builder.
+	DefaultTokenAudience string
+	// AllowTokenAudienceOverride honors the openshift.io/build.token-audience
+	// annotation of builds, which projects a token with the given audience instead.
+	// Otherwise the annotation is ignored, so builds cannot obtain tokens for
+	// audiences the administrator did not configure.
+	AllowTokenAudienceOverride bool
+
+	// AllowedRegistries, when set, restricts the registries the custom builder
+	// image may be pulled from. The internal registry and RegistryMirrors are
+	// always allowed.
+	AllowedRegistries []string
+	// RegistryMirrors are the registries configured as mirrors for the cluster.
+	RegistryMirrors []string
+
+	// TerminationGracePeriodSecondsPerGi, when set, extends the termination grace
+	// period of the build pod by this many seconds for every Gi of the output image
+	// size estimated by the openshift.io/build.image-size annotation, up to
+	// MaxTerminationGracePeriodSeconds.
+	TerminationGracePeriodSecondsPerGi int64
+	MaxTerminationGracePeriodSeconds   int64
+
+	// InputSourcesAsEnvFrom exposes the build's input secrets and config maps to
+	// the builder as environment variables via envFrom, rather than mounting them.
+	InputSourcesAsEnvFrom bool
+
+	// AllowDetachedPods honors the openshift.io/build.detached-pod debug
+	// annotation, which leaves the build pod without an owner reference so it is
+	// not garbage collected with the build.
+	AllowDetachedPods bool
+
+	// ResolvConf, when set, references a ConfigMap key which is mounted over the
+	// builder's /etc/resolv.conf. When ConfigMapLister is set, the key is verified
+	// to exist. Builds whose pod ends up using the None DNS policy with a DNS config
+	// are rejected, as the kubelet writes their resolv.conf.
+	ResolvConf *corev1.ConfigMapKeySelector
+
+	// EntrypointWrapper, when set, references an executable script in a ConfigMap
+	// which wraps the builder entrypoint. The script is invoked with
+	// EntrypointWrapperCommand, which is required as the builder container does not
+	// set a command and the entrypoint of the builder image is not known. When
+	// ConfigMapLister is set, the key is verified to exist. The command applies to
+	// every custom build, so the wrapper only suits clusters whose custom builder
+	// images all share the same entrypoint; builds using an image with another
+	// entrypoint run the wrong command.
+	EntrypointWrapper        *corev1.ConfigMapKeySelector
+	EntrypointWrapperCommand []string
+
+	// MountNodeCATrust mounts the node's /etc/pki directory read-only into the
+	// builder. This grants the build access to the host, so it must be
+	// explicitly enabled.
+	MountNodeCATrust bool
+
+	// CorrelationAnnotation is the build annotation holding a correlation ID from an
+	// external orchestrator, which is copied into the CorrelationLabel pod label.
+	CorrelationAnnotation string
+	CorrelationLabel      string
+
+	// StrictEncoding verifies that the encoded build only contains fields known to
+	// the requested buildAPIVersion and survives a decode and encode round trip for
+	// it unchanged, failing the build otherwise.
+	StrictEncoding bool
+
+	// OutputImageLabel, when set, is the pod label holding the repository of the
+	// build output image.
+	OutputImageLabel string
+
+	// BuildCacheURL, when set, is passed to the builder as the location of a remote
+	// layer cache, together with the credentials from BuildCacheSecret.
+	BuildCacheURL    string
+	BuildCacheSecret *corev1.LocalObjectReference
+
+	// GitConfig, when set, references a ConfigMap key which is mounted as the
+	// builder's global git configuration. When ConfigMapLister is set, the key is
+	// verified to exist.
+	GitConfig *corev1.ConfigMapKeySelector
+
+	// RequireCompletionDeadline rejects builds which do not set a positive
+	// completionDeadlineSeconds.
+	RequireCompletionDeadline bool
+
+	// RegistryAuthFileEnvVar is the name of the environment variable pointing at the
+	// mounted push or pull secret. Defaults to REGISTRY_AUTH_FILE. It is only set when
+	// SecretLister shows the secret is of type kubernetes.io/dockerconfigjson.
+	RegistryAuthFileEnvVar string
+
+	// RejectOverlappingImagePaths rejects builds whose input images are extracted
+	// into destination directories nested in one another.
+	RejectOverlappingImagePaths bool
+
+	// SecretsDir, when set, is the directory under which the build's input secrets
+	// and the custom strategy secrets are all mounted, each in a subdirectory named
+	// after the secret. The directory is passed to the builder in BUILD_SECRETS_DIR.
+	SecretsDir string
+
+	// DisallowedImageTags, when set, rejects custom builder images referenced by one
+	// of these mutable tags. Images referenced by digest are always allowed.
+	DisallowedImageTags []string
+
+	// AnnotateBuildChecksum stamps the SHA-256 checksum of the build passed to the
+	// builder in the BUILD environment variable on the pod, so alterations of the
+	// build definition can be detected. The checksum is taken when the pod is
+	// finalized, over the BUILD value the builder receives.
+	AnnotateBuildChecksum bool
+
+	// NodeSelectorPolicyNamespace and NodeSelectorPolicyName, when set, reference a
+	// ConfigMap holding a node selector for each namespace, keyed by namespace name,
+	// in the key1=value1,key2=value2 format. The selector of the build's namespace
+	// is merged into the node selector of the build pod, and takes precedence over
+	// the selector of the build. The ConfigMap is read from ConfigMapLister.
+	NodeSelectorPolicyNamespace string
+	NodeSelectorPolicyName      string
+	ConfigMapLister             v1lister.ConfigMapLister
+
+	// FeatureFlags are environment variables passed to all builders to toggle
+	// builder features. Their names must start with BUILD_FEATURE_. Builds can
+	// override a flag by setting the same variable in the strategy environment.
+	FeatureFlags []corev1.EnvVar
+
+	// AllowedSecretMountPrefixes, when set, requires the custom strategy secrets to
+	// be mounted at distinct absolute paths within one of these directories.
+	AllowedSecretMountPrefixes []string
+
+	// TraceAnnotation is the build annotation holding the trace context of the
+	// build. When a build carries it, its value is added to the labels passed to the
+	// builder in BUILD_IMAGE_LABELS as the TraceImageLabel label, linking the output
+	// image to the build trace.
+	TraceAnnotation string
+	TraceImageLabel string
+
+	// MaxImageSources is the maximum number of input images a build may reference.
+	// Defaults to 100.
+	MaxImageSources int
+
+	// Clock provides the build creation time when the build has no creation
+	// timestamp. Defaults to the real clock.
+	Clock clock.PassiveClock
+
+	// GPGKeyringSecret, when set, references a secret holding a GPG keyring which
+	// is mounted read-only into the builder at BUILD_GPG_KEYRING_DIR, to sign the
+	// output image. The builder must import it into the writable GNUPGHOME first.
+	GPGKeyringSecret *corev1.LocalObjectReference
+
+	// RequirePrivilegedCompletionDeadline rejects builds which expose the docker
+	// socket without setting a positive completionDeadlineSeconds.
+	RequirePrivilegedCompletionDeadline bool
+
+	// DeadlineWatcherImage, when set, is the image of a sidecar which shares the
+	// process namespace of the builder and signals it to shut down gracefully
+	// before the active deadline of the pod is reached. The sidecar must exit once
+	// the builder process exits, as the pod only completes when both have exited. A
+	// non-zero exit of the sidecar does not fail a build whose builder succeeded.
+	DeadlineWatcherImage string
+
+	// CacheKeyLabel, when set, is the build label holding the key of the cache used
+	// by the build. The label is copied to the build pod, which prefers nodes
+	// running build pods with the same cache key.
+	CacheKeyLabel string
+
+	// AllowReservedEnvOverrides allows builds to set BUILD and the environment
+	// variables listed in reservedEnvVars which the build controller sets in their
+	// pod. Such builds are rejected by default.
+	AllowReservedEnvOverrides bool
+
+	// PushConcurrency is passed to the builder in BUILD_PUSH_CONCURRENCY as the
+	// number of layers or manifests to push in parallel. Defaults to 5.
+	PushConcurrency int
+
+	// AllowDockerSocketPathOverride honors the openshift.io/build.docker-socket-path
+	// annotation, which selects the path of the docker socket exposed to builds
+	// with ExposeDockerSocket, for nodes where it is not at the default path.
+	AllowDockerSocketPathOverride bool
+
+	// EnvironmentLabel, when set, is the namespace label naming the environment,
+	// such as dev or prod, builds in the namespace run in. The environment is passed
+	// to the builder in BUILD_ENVIRONMENT and set as the same label on the build pod.
+	// Namespaces are read from NamespaceLister.
+	EnvironmentLabel string
+	NamespaceLister  v1lister.NamespaceLister
+
+	// RequireResourceLimits rejects builds which do not set both cpu and memory
+	// limits. Limits set by build defaults and overrides are taken into account.
+	RequireResourceLimits bool
+
+	// RegistriesConf, when set, references a ConfigMap key in the build namespace
+	// which is mounted as the containers registries.conf of the builder. When
+	// ConfigMapLister is set, the key is verified to exist.
+	RegistriesConf *corev1.ConfigMapKeySelector
+
+	// SourceDateEpoch passes the timestamp of the resolved source commit, read from
+	// the CommitDateAnnotation build annotation, to the builder in SOURCE_DATE_EPOCH
+	// for reproducible builds. Builds created without the annotation, which
+	// includes builds started from BuildConfigs, use their creation time.
+	SourceDateEpoch bool
+
+	// ImageResolver, when set, resolves the custom builder image to a digest which
+	// is recorded on the build pod, while the pod keeps pulling the image by the
+	// reference of the build.
+	ImageResolver ImageResolver
+
+	// SetQuotaLabel sets the QuotaLabelKey=QuotaLabelValue label on all build pods,
+	// so ResourceQuota scope selectors can target builds. The label defaults to
+	// workload-type=build.
+	SetQuotaLabel   bool
+	QuotaLabelKey   string
+	QuotaLabelValue string
+
+	// RejectSecretPathConflicts rejects builds which reference the same secret as an
+	// input secret and as a custom strategy secret mounted at a different path.
+	RejectSecretPathConflicts bool
+
+	// ImageLabelsFromBuildLabels lists the build labels, such as the labels
+	// inherited from the BuildConfig, which are added to the labels passed to the
+	// builder in BUILD_IMAGE_LABELS when the build carries them.
+	ImageLabelsFromBuildLabels []string
+
+	// DefaultBuilderImage, when set, is the custom builder image used by builds
+	// which do not specify one, instead of failing them.
+	DefaultBuilderImage string
+
+	// AllowPullSecretAnnotation honors the openshift.io/build.pull-secret
+	// annotation, which names a secret mounted as the pull secret of the build in
+	// place of the custom strategy pull secret.
+	AllowPullSecretAnnotation bool
+
+	// EnvFromConfigMap, when set, names a ConfigMap in the build namespace whose keys
+	// are all exposed to the builder as environment variables. Variables set by the
+	// build, or by the build controller, take precedence over them. Builds in
+	// namespaces without the ConfigMap run without its variables. When
+	// RejectEnvFromConfigMapCollisions is set, the ConfigMap is read from
+	// ConfigMapLister and rejected if it sets variables reserved by the build
+	// controller.
+	EnvFromConfigMap                 string
+	RejectEnvFromConfigMapCollisions bool
+
+	// AnnotateEnvNames records the names, but not the values, of the environment
+	// variables of the builder on the build pod, for auditing, once build defaults and
+	// overrides have added theirs.
+	AnnotateEnvNames bool
+
+	// RejectAmbiguousOutput rejects builds which do not push to exactly one
+	// resolved destination: builds whose output is not resolved to an image, builds
+	// with output settings but no output, and builds setting an output destination
+	// in their environment.
+	RejectAmbiguousOutput bool
+
+	// LockToken, when set, is passed to the builder in BUILD_LOCK_TOKEN to
+	// coordinate exclusive access to shared resources with an external lock
+	// service. GenerateLockToken passes a token unique to each build pod instead.
+	LockToken         string
+	GenerateLockToken bool
+
+	// WarnLossyEnvConversion records the environment variables of the build whose
+	// valueFrom source cannot be represented in the encoded build in the
+	// openshift.io/build.env-warnings pod annotation, instead of dropping them
+	// silently.
+	WarnLossyEnvConversion bool
+
+	// AllowedServiceAccountOverrides lists, for each namespace, the service
+	// accounts builds may run as by naming them in the
+	// openshift.io/build.service-account annotation, overriding the service
+	// account of the build. The annotation is ignored when no overrides are allowed.
+	AllowedServiceAccountOverrides map[string][]string
+
+	// DownwardAPIMountPath, when set, is the directory where the labels and
+	// annotations of the build pod are exposed to the builder as files.
+	DownwardAPIMountPath string
+
+	// ImageMetadataResolver, when set, is used to reject builds whose custom builder
+	// image is not available for the architecture selected by the node selector of
+	// the build pod, once build defaults and overrides are applied to it.
+	ImageMetadataResolver ImageMetadataResolver
+
+	// BuildCacheTTL, when set, is recorded on the pods of builds using the remote
+	// build cache, for cache pruning controllers to clean up caches which have not
+	// been used for this long.
+	BuildCacheTTL time.Duration
+
+	// StorageOptions, when set, is passed to the builder in the STORAGE_OPTS
+	// environment variable as a comma-separated list of containers/storage
+	// options, such as overlay mount flags, when the builder uses the
+	// container-storage emptyDir volumes.
+	StorageOptions string
+
+	// SecretLister is used to look up the secrets referenced by builds.
+	SecretLister v1lister.SecretLister
+	// ValidateReferencedSecrets rejects builds referencing secrets which do not
+	// exist in the namespace of the build. It requires SecretLister.
+	ValidateReferencedSecrets bool
+
+	// GenerateCorrelationID passes the correlation ID of the build to the builder
+	// in the BUILD_CORRELATION_ID environment variable and sets it in the
+	// CorrelationLabel pod label. The ID is read from the CorrelationAnnotation
+	// build annotation, and derived from the build UID when it is absent.
+	GenerateCorrelationID bool
+
+	// RestrictMountPaths rejects builds whose pod mounts a volume outside of the
+	// AllowedMountPathPrefixes directories, such as over /etc or /bin in the
+	// builder image.
+	RestrictMountPaths bool
+	// AllowedMountPathPrefixes are the directories volumes may be mounted in when
+	// RestrictMountPaths is set. It defaults to the paths the build controller
+	// mounts volumes at, including the configured SecretsDir and DownwardAPIMountPath,
+	// the docker socket path of the build and the git config path in the builder's HOME.
+	AllowedMountPathPrefixes []string
+
+	// InjectBuildConfigGeneration passes the generation of the BuildConfig the
+	// build was created from to the builder in the BUILD_CONFIG_GENERATION
+	// environment variable, so it can detect running an outdated BuildConfig.
+	InjectBuildConfigGeneration bool
+
+	// AggregateImagePullSecrets sets the image pull secrets of the build pod to the
+	// secrets from, in order of precedence, the openshift.io/build.pull-secret
+	// build annotation when AllowPullSecretAnnotation is set, the service account of
+	// the build, DefaultImagePullSecrets and GlobalPullSecret. Secrets named by several sources are listed once.
+	AggregateImagePullSecrets bool
+	// ServiceAccountLister, when set, is used to look up the image pull secrets of
+	// the service account of the build.
+	ServiceAccountLister    v1lister.ServiceAccountLister
+	DefaultImagePullSecrets []corev1.LocalObjectReference
+	GlobalPullSecret        *corev1.LocalObjectReference
 }
 
 // CreateBuildPod creates the pod to be used for the Custom build
@@ -38,20 +391,76 @@ func (bs *CustomBuildStrategy) CreateBuildPod(build *buildv1.Build, additionalCA
 	if strategy == nil {
 		return nil, errors.New("CustomBuildStrategy cannot be executed without CustomStrategy parameters")
 	}
+	if len(strategy.From.Name) == 0 && len(bs.DefaultBuilderImage) > 0 {
+		klog.V(4).Infof("Using the default custom builder image %s for build %s/%s", bs.DefaultBuilderImage, build.Namespace, build.Name)
+		build = build.DeepCopy()
+		strategy = build.Spec.Strategy.CustomStrategy
+		strategy.From = corev1.ObjectReference{Kind: "DockerImage", Name: bs.DefaultBuilderImage}
+	}
 
-	codec := customBuildEncodingCodecFactory.LegacyCodec(buildv1.GroupVersion)
+	if bs.RequireCompletionDeadline || (bs.RequirePrivilegedCompletionDeadline && strategy.ExposeDockerSocket) {
+		if err := validateCompletionDeadline(build); err != nil {
+			return nil, err
+		}
+	}
+	if bs.RejectOverlappingImagePaths {
+		if err := validateImageSourcePaths(build.Spec.Source.Images); err != nil {
+			return nil, err
+		}
+	}
+	pullSecret := strategy.PullSecret
+	if bs.AllowPullSecretAnnotation {
+		var err error
+		pullSecret, err = pullSecretForBuild(build, pullSecret)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if bs.RejectAmbiguousOutput {
+		if err := validateSingleOutput(build); err != nil {
+			return nil, err
+		}
+	}
+	if err := validateImageSourceCount(build.Spec.Source.Images, bs.MaxImageSources); err != nil {
+		return nil, err
+	}
+	if len(bs.AllowedSecretMountPrefixes) > 0 {
+		if err := validateSecretMountPaths(strategy.Secrets, bs.AllowedSecretMountPrefixes); err != nil {
+			return nil, err
+		}
+	}
+	if bs.ValidateReferencedSecrets {
+		if err := validateReferencedSecrets(bs.SecretLister, build, pullSecret); err != nil {
+			return nil, err
+		}
+	}
+
+	gv := buildv1.GroupVersion
 	if len(strategy.BuildAPIVersion) != 0 {
-		gv, err := schema.ParseGroupVersion(strategy.BuildAPIVersion)
+		var err error
+		gv, err = schema.ParseGroupVersion(strategy.BuildAPIVersion)
 		if err != nil {
 			return nil, &FatalError{fmt.Sprintf("failed to parse buildAPIVersion specified in custom build strategy (%q): %v", strategy.BuildAPIVersion, err)}
 		}
-		codec = customBuildEncodingCodecFactory.LegacyCodec(gv)
 	}
+	codec := customBuildEncodingCodecFactory.LegacyCodec(gv)
 
 	data, err := runtime.Encode(codec, build)
 	if err != nil {
 		return nil, fmt.Errorf("failed to encode the build: %v", err)
 	}
+	if bs.StrictEncoding {
+		if err := validateStrictBuildEncoding(data, gv); err != nil {
+			return nil, err
+		}
+	}
+	var envWarnings []string
+	if bs.WarnLossyEnvConversion {
+		envWarnings, err = lossyEnvWarnings(build, data, gv)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	containerEnv := []corev1.EnvVar{
 		{Name: "BUILD", Value: string(data)},
@@ -61,7 +470,37 @@ func (bs *CustomBuildStrategy) CreateBuildPod(build *buildv1.Build, additionalCA
 	if build.Spec.Source.Git != nil {
 		addSourceEnvVars(build.Spec.Source, &containerEnv)
 	}
+	addNoSourceEnvVar(build.Spec.Source, &containerEnv)
 	addTrustedCAMountEnvVar(build.Spec.MountTrustedCA, &containerEnv)
+	addNumericAnnotationEnvVar(build, buildv1.BuildNumberAnnotation, "BUILD_NUMBER", &containerEnv)
+	if bs.InjectBuildConfigGeneration {
+		addNumericAnnotationEnvVar(build, buildConfigGenerationAnnotation, "BUILD_CONFIG_GENERATION", &containerEnv)
+	}
+	addBuildCreatedEnvVar(build, bs.Clock, &containerEnv)
+	addLockTokenEnvVar(bs.LockToken, bs.GenerateLockToken, &containerEnv)
+	var correlationID string
+	if bs.GenerateCorrelationID {
+		correlationID = buildCorrelationID(build, bs.CorrelationAnnotation)
+		if len(correlationID) > 0 {
+			containerEnv = append(containerEnv, corev1.EnvVar{Name: "BUILD_CORRELATION_ID", Value: correlationID})
+		}
+	}
+	if bs.SourceDateEpoch {
+		addSourceDateEpochEnvVar(build, &containerEnv)
+	}
+	if err := addPushConcurrencyEnvVar(bs.PushConcurrency, &containerEnv); err != nil {
+		return nil, err
+	}
+	environment := ""
+	if len(bs.EnvironmentLabel) > 0 {
+		environment, err = buildEnvironment(bs.NamespaceLister, build.Namespace, bs.EnvironmentLabel)
+		if err != nil {
+			return nil, err
+		}
+		if len(environment) > 0 {
+			containerEnv = append(containerEnv, corev1.EnvVar{Name: "BUILD_ENVIRONMENT", Value: environment})
+		}
+	}
 
 	if build.Spec.Output.To != nil {
 		addOutputEnvVars(build.Spec.Output.To, &containerEnv)
@@ -74,19 +513,58 @@ func (bs *CustomBuildStrategy) CreateBuildPod(build *buildv1.Build, additionalCA
 		return nil, errors.New("CustomBuildStrategy cannot be executed without image")
 	}
 
+	if len(bs.AllowedRegistries) > 0 {
+		allowed := append([]string{internalRegistryHost}, bs.RegistryMirrors...)
+		allowed = append(allowed, bs.AllowedRegistries...)
+		if err := validateImageRegistry(strategy.From.Name, allowed); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(bs.DisallowedImageTags) > 0 {
+		if err := validateImageTag(strategy.From.Name, bs.DisallowedImageTags); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := addManifestAnnotationsEnvVar(bs.ManifestAnnotations, &containerEnv); err != nil {
+		return nil, fmt.Errorf("failed to encode the manifest annotations: %v", err)
+	}
+	extraImageLabels := append(buildLabelImageLabels(build, bs.ImageLabelsFromBuildLabels), traceImageLabels(build, bs.TraceAnnotation, bs.TraceImageLabel)...)
+	if err := addImageLabelsEnvVar(build.Spec.Output.ImageLabels, extraImageLabels, &containerEnv); err != nil {
+		return nil, fmt.Errorf("failed to encode the image labels: %v", err)
+	}
+
+	if err := addFeatureFlagEnvVars(bs.FeatureFlags, strategy.Env, &containerEnv); err != nil {
+		return nil, err
+	}
+
 	if len(strategy.Env) > 0 {
 		containerEnv = append(containerEnv, strategy.Env...)
 	}
 
+	socketPath := dockerSocketPath
 	if strategy.ExposeDockerSocket {
 		klog.V(2).Infof("ExposeDockerSocket is enabled for %s build", build.Name)
-		containerEnv = append(containerEnv, corev1.EnvVar{Name: "DOCKER_SOCKET", Value: dockerSocketPath})
+		if bs.AllowDockerSocketPathOverride {
+			socketPath, err = dockerSocketPathForBuild(build)
+			if err != nil {
+				return nil, err
+			}
+		}
+		containerEnv = append(containerEnv, corev1.EnvVar{Name: "DOCKER_SOCKET", Value: socketPath})
 	}
 
 	serviceAccount := build.Spec.ServiceAccount
 	if len(serviceAccount) == 0 {
 		serviceAccount = buildutil.BuilderServiceAccountName
 	}
+	if len(bs.AllowedServiceAccountOverrides) > 0 {
+		serviceAccount, err = serviceAccountForBuild(build, serviceAccount, bs.AllowedServiceAccountOverrides)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	securityContext := securityContextForBuild(strategy.Env)
 	pod := &corev1.Pod{
@@ -111,7 +589,32 @@ func (bs *CustomBuildStrategy) CreateBuildPod(build *buildv1.Build, additionalCA
 		},
 	}
 
+	if len(bs.NodeSelectorPolicyName) > 0 {
+		if err := setupNodeSelectorPolicy(pod, bs.ConfigMapLister, bs.NodeSelectorPolicyNamespace, bs.NodeSelectorPolicyName); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(bs.EnvFromConfigMap) > 0 {
+		// the ConfigMap is configured for the whole cluster and may not exist in the
+		// namespace of every build
+		optional := true
+		pod.Spec.Containers[0].EnvFrom = append(pod.Spec.Containers[0].EnvFrom, corev1.EnvFromSource{
+			ConfigMapRef: &corev1.ConfigMapEnvSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: bs.EnvFromConfigMap},
+				Optional:             &optional,
+			},
+		})
+	}
+
+	if bs.AggregateImagePullSecrets {
+		if err := setupImagePullSecrets(pod, build, bs.AllowPullSecretAnnotation, bs.ServiceAccountLister, bs.DefaultImagePullSecrets, bs.GlobalPullSecret); err != nil {
+			return nil, err
+		}
+	}
+
 	pod = setupActiveDeadline(pod, build)
+	setupTerminationGracePeriod(pod, build, bs.TerminationGracePeriodSecondsPerGi, bs.MaxTerminationGracePeriodSeconds)
 
 	if !strategy.ForcePull {
 		pod.Spec.Containers[0].ImagePullPolicy = corev1.PullIfNotPresent
@@ -126,19 +629,194 @@ func (bs *CustomBuildStrategy) CreateBuildPod(build *buildv1.Build, additionalCA
 	}
 
 	if strategy.ExposeDockerSocket {
-		setupDockerSocket(pod)
+		setupDockerSocket(pod, socketPath)
+	}
+	setupDockerSecrets(pod, &pod.Spec.Containers[0], build.Spec.Output.PushSecret, pullSecret, build.Spec.Source.Images)
+	if err := addRegistryAuthFileEnvVar(&pod.Spec.Containers[0], bs.SecretLister, build.Namespace, build.Spec.Output.PushSecret, pullSecret, bs.RegistryAuthFileEnvVar); err != nil {
+		return nil, err
+	}
+	if bs.AllowDetachedPods && isDetachedPodRequested(build) {
+		klog.V(0).Infof("Build %s/%s requested a detached pod, pod %s will not be garbage collected with the build", build.Namespace, build.Name, pod.Name)
+		metav1.SetMetaDataAnnotation(&pod.ObjectMeta, DetachedBuildUIDAnnotation, string(build.UID))
+	} else {
+		setOwnerReference(pod, build)
+	}
+	setupCriticalBuildAnnotations(pod, build, bs.CriticalBuildAnnotations)
+	setupBuildAttemptAnnotation(pod, build)
+	if bs.GenerateCorrelationID {
+		setupCorrelationIDLabel(pod, correlationID, bs.CorrelationLabel)
+	} else {
+		setupCorrelationLabel(pod, build, bs.CorrelationAnnotation, bs.CorrelationLabel)
+	}
+	setupOutputImageLabel(pod, build.Spec.Output.To, bs.OutputImageLabel)
+	if bs.SetQuotaLabel {
+		setupQuotaLabel(pod, bs.QuotaLabelKey, bs.QuotaLabelValue)
+	}
+	setupCacheAffinity(pod, build, bs.CacheKeyLabel)
+	if bs.ImageResolver != nil {
+		setupBuilderImageDigestAnnotation(pod, bs.ImageResolver, strategy.From.Name)
+	}
+	if len(environment) > 0 {
+		pod.Labels[bs.EnvironmentLabel] = environment
 	}
-	setupDockerSecrets(pod, &pod.Spec.Containers[0], build.Spec.Output.PushSecret, strategy.PullSecret, build.Spec.Source.Images)
-	setOwnerReference(pod, build)
 	setupSourceSecrets(pod, &pod.Spec.Containers[0], build.Spec.Source.SourceSecret)
-	setupInputSecrets(pod, &pod.Spec.Containers[0], build.Spec.Source.Secrets)
-	setupAdditionalSecrets(pod, &pod.Spec.Containers[0], build.Spec.Strategy.CustomStrategy.Secrets)
+	inputSecrets := build.Spec.Source.Secrets
+	if bs.InputSourcesAsEnvFrom {
+		if err := setupInputEnvFrom(&pod.Spec.Containers[0], build.Spec.Source.Secrets, build.Spec.Source.ConfigMaps); err != nil {
+			return nil, err
+		}
+		inputSecrets = nil
+	}
+	if len(bs.SecretsDir) > 0 {
+		if err := setupAggregatedSecrets(pod, &pod.Spec.Containers[0], bs.SecretsDir, inputSecrets, strategy.Secrets); err != nil {
+			return nil, err
+		}
+	} else {
+		if bs.RejectSecretPathConflicts {
+			if err := validateSecretPathConflicts(inputSecrets, strategy.Secrets); err != nil {
+				return nil, err
+			}
+		}
+		setupInputSecrets(pod, &pod.Spec.Containers[0], inputSecrets)
+		setupAdditionalSecrets(pod, &pod.Spec.Containers[0], strategy.Secrets)
+	}
+	setupServiceAccountToken(pod, &pod.Spec.Containers[0], build, bs.DefaultTokenAudience, bs.AllowTokenAudienceOverride)
+	if err := setupResolvConf(pod, &pod.Spec.Containers[0], bs.ResolvConf, bs.ConfigMapLister); err != nil {
+		return nil, err
+	}
+	if err := setupBuildCache(pod, &pod.Spec.Containers[0], bs.BuildCacheURL, bs.BuildCacheSecret); err != nil {
+		return nil, err
+	}
+	if len(bs.BuildCacheURL) > 0 && bs.BuildCacheTTL > 0 {
+		metav1.SetMetaDataAnnotation(&pod.ObjectMeta, BuildCacheTTLAnnotation, bs.BuildCacheTTL.String())
+	}
+	if err := setupGitConfig(pod, &pod.Spec.Containers[0], bs.GitConfig, bs.ConfigMapLister); err != nil {
+		return nil, err
+	}
+	if err := setupGPGKeyring(pod, &pod.Spec.Containers[0], bs.GPGKeyringSecret); err != nil {
+		return nil, err
+	}
+	if err := setupDownwardAPIVolume(pod, &pod.Spec.Containers[0], bs.DownwardAPIMountPath); err != nil {
+		return nil, err
+	}
+	if err := setupRegistriesConf(pod, &pod.Spec.Containers[0], bs.RegistriesConf, bs.ConfigMapLister); err != nil {
+		return nil, err
+	}
+	if bs.MountNodeCATrust {
+		setupNodeCATrust(pod, &pod.Spec.Containers[0])
+	}
+	if err := setupEntrypointWrapper(pod, &pod.Spec.Containers[0], bs.EntrypointWrapper, bs.EntrypointWrapperCommand, bs.ConfigMapLister); err != nil {
+		return nil, err
+	}
 	setupContainersConfigs(build, pod)
 	setupBuildCAs(build, pod, additionalCAs, internalRegistryHost)
 	setupContainersStorage(pod, &pod.Spec.Containers[0])
+	if err := setupStorageOptions(pod, &pod.Spec.Containers[0], bs.StorageOptions); err != nil {
+		return nil, err
+	}
 	if securityContext == nil || securityContext.Privileged == nil || !*securityContext.Privileged {
 		setupBuilderAutonsUser(build, strategy.Env, pod)
 		setupBuilderDeviceFUSE(pod)
 	}
+	if len(bs.DeadlineWatcherImage) > 0 {
+		setupDeadlineWatcher(pod, bs.DeadlineWatcherImage)
+	}
+	reservedEnv := reservedEnvNames(pod.Spec.Containers[0].Env, strategy.Env)
+	if !bs.AllowReservedEnvOverrides {
+		if err := validateReservedEnv(strategy.Env, reservedEnv); err != nil {
+			return nil, err
+		}
+	}
+	if len(bs.EnvFromConfigMap) > 0 && bs.RejectEnvFromConfigMapCollisions {
+		if err := validateEnvFromConfigMap(bs.ConfigMapLister, build.Namespace, bs.EnvFromConfigMap, reservedEnv); err != nil {
+			return nil, err
+		}
+	}
+	if bs.RestrictMountPaths {
+		allowedPrefixes := bs.AllowedMountPathPrefixes
+		if len(allowedPrefixes) == 0 {
+			allowedPrefixes = allowedMountPathPrefixes(&pod.Spec.Containers[0], socketPath, bs.SecretsDir, bs.DownwardAPIMountPath)
+		}
+		if err := validateMountPaths(pod, allowedPrefixes); err != nil {
+			return nil, err
+		}
+	}
+	setupSecurityPostureAnnotation(pod)
+	if len(envWarnings) > 0 {
+		metav1.SetMetaDataAnnotation(&pod.ObjectMeta, EnvWarningsAnnotation, strings.Join(envWarnings, "; "))
+	}
 	return pod, nil
 }
+
+// FinalizeBuildPod updates the pod created for the Custom build once build defaults
+// and overrides are applied to it and the build environment is resolved.
+func (bs *CustomBuildStrategy) FinalizeBuildPod(build *buildv1.Build, pod *corev1.Pod) error {
+	setupQOSClassAnnotation(pod)
+	if bs.ResolvConf != nil {
+		if err := validateResolvConfDNSPolicy(pod); err != nil {
+			return err
+		}
+	}
+	if bs.RequireResourceLimits {
+		if err := validateResourceLimits(build, &pod.Spec.Containers[0]); err != nil {
+			return err
+		}
+	}
+	if bs.ImageMetadataResolver != nil {
+		if err := validateImageArchitecture(pod, bs.ImageMetadataResolver, pod.Spec.Containers[0].Image); err != nil {
+			return err
+		}
+	}
+	addCPULimitEnvVar(&pod.Spec.Containers[0])
+	if bs.AnnotateEnvNames {
+		setupEnvNamesAnnotation(pod, &pod.Spec.Containers[0])
+	}
+	if bs.AnnotateBuildChecksum {
+		setupBuildChecksumAnnotation(pod, &pod.Spec.Containers[0])
+	}
+	return nil
+}
+
+// validateStrictBuildEncoding decodes the encoded build with a strict decoder for the
+// given version and encodes it again, returning a FatalError if the build contains
+// fields unknown to the version or does not survive the round trip unchanged.
+func validateStrictBuildEncoding(data []byte, gv schema.GroupVersion) error {
+	obj, _, err := customBuildStrictCodecFactory.UniversalDecoder(gv).Decode(data, nil, &buildv1.Build{})
+	if err != nil {
+		return &FatalError{fmt.Sprintf("the build is not valid for buildAPIVersion %q: %v", gv.String(), err)}
+	}
+	reencoded, err := runtime.Encode(customBuildEncodingCodecFactory.LegacyCodec(gv), obj)
+	if err != nil {
+		return fmt.Errorf("failed to encode the build: %v", err)
+	}
+	var original, roundTripped map[string]interface{}
+	if err := json.Unmarshal(data, &original); err != nil {
+		return fmt.Errorf("failed to decode the encoded build: %v", err)
+	}
+	if err := json.Unmarshal(reencoded, &roundTripped); err != nil {
+		return fmt.Errorf("failed to decode the encoded build: %v", err)
+	}
+	if !reflect.DeepEqual(original, roundTripped) {
+		return &FatalError{fmt.Sprintf("the build cannot be represented in buildAPIVersion %q without losing fields", gv.String())}
+	}
+	return nil
+}
+
+// lossyEnvWarnings returns a warning for each environment variable of the custom
+// strategy whose valueFrom source is unknown, or is not preserved in the build
+// encoded for the given version.
+func lossyEnvWarnings(build *buildv1.Build, data []byte, gv schema.GroupVersion) ([]string, error) {
+	encoded := &buildv1.Build{}
+	if _, _, err := customBuildEncodingCodecFactory.UniversalDecoder(gv).Decode(data, nil, encoded); err != nil {
+		return nil, fmt.Errorf("failed to decode the encoded build: %v", err)
+	}
+	var encodedEnv []corev1.EnvVar
+	if encoded.Spec.Strategy.CustomStrategy != nil {
+		encodedEnv = encoded.Spec.Strategy.CustomStrategy.Env
+	}
+	warnings := envValueFromWarnings(build.Spec.Strategy.CustomStrategy.Env, encodedEnv, gv.String())
+	for _, w := range warnings {
+		klog.V(2).Infof("Build %s/%s: %s", build.Namespace, build.Name, w)
+	}
+	return warnings, nil
+}
@@ -1,18 +1,27 @@
 package strategy
 
 import (
+	"crypto/sha256"
+	"encoding/json"
 	"fmt"
+	"path/filepath"
 	"reflect"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/apimachinery/pkg/util/validation"
+	v1lister "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
 	kapihelper "k8s.io/kubernetes/pkg/apis/core/helper"
+	clocktesting "k8s.io/utils/clock/testing"
 
 	buildv1 "github.com/openshift/api/build/v1"
 	"github.com/openshift/openshift-controller-manager/pkg/build/buildutil"
@@ -281,3 +290,3296 @@ func TestCustomCreateBuildPodAutonsUser(t *testing.T) {
 		},
 	)
 }
+
+func TestCustomCreateBuildPodCriticalBuild(t *testing.T) {
+	strategy := CustomBuildStrategy{
+		CriticalBuildAnnotations: map[string]string{
+			"cluster-autoscaler.kubernetes.io/safe-to-evict": "false",
+		},
+	}
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		expected    bool
+	}{
+		{
+			name:        "critical build",
+			annotations: map[string]string{criticalBuildAnnotation: "true"},
+			expected:    true,
+		},
+		{
+			name:        "non-critical build",
+			annotations: map[string]string{criticalBuildAnnotation: "false"},
+		},
+		{
+			name: "not annotated",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			build := mockCustomBuild(false, false)
+			build.Annotations = tc.annotations
+			pod, err := strategy.CreateBuildPod(build, nil, testInternalRegistryHost)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			_, found := pod.Annotations["cluster-autoscaler.kubernetes.io/safe-to-evict"]
+			if found != tc.expected {
+				t.Errorf("expected critical build annotation present=%v, got annotations %v", tc.expected, pod.Annotations)
+			}
+		})
+	}
+}
+
+func TestCustomCreateBuildPodManifestAnnotations(t *testing.T) {
+	annotations := []buildv1.ImageLabel{
+		{Name: "org.opencontainers.image.source", Value: "https://github.com/openshift/ruby-hello-world"},
+		{Name: "io.openshift/build:ref", Value: "refs/heads/main"},
+	}
+	strategy := CustomBuildStrategy{ManifestAnnotations: annotations}
+
+	pod, err := strategy.CreateBuildPod(mockCustomBuild(false, false), nil, testInternalRegistryHost)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	env, ok := findEnvVar(pod.Spec.Containers[0].Env, "BUILD_MANIFEST_ANNOTATIONS")
+	if !ok {
+		t.Fatalf("expected BUILD_MANIFEST_ANNOTATIONS to be set")
+	}
+	var decoded []buildv1.ImageLabel
+	if err := json.Unmarshal([]byte(env.Value), &decoded); err != nil {
+		t.Fatalf("unexpected error decoding %q: %v", env.Value, err)
+	}
+	if !reflect.DeepEqual(annotations, decoded) {
+		t.Errorf("expected annotations %v, got %v", annotations, decoded)
+	}
+
+	strategy = CustomBuildStrategy{}
+	pod, err = strategy.CreateBuildPod(mockCustomBuild(false, false), nil, testInternalRegistryHost)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if env, ok := findEnvVar(pod.Spec.Containers[0].Env, "BUILD_MANIFEST_ANNOTATIONS"); ok {
+		t.Errorf("expected BUILD_MANIFEST_ANNOTATIONS to be omitted, got %q", env.Value)
+	}
+}
+
+func TestCustomCreateBuildPodTokenAudience(t *testing.T) {
+	tests := []struct {
+		name             string
+		defaultAudience  string
+		allowOverride    bool
+		buildAudience    string
+		expectedAudience string
+	}{
+		{
+			name: "disabled",
+		},
+		{
+			name:             "default audience",
+			defaultAudience:  "https://vault.example.com",
+			expectedAudience: "https://vault.example.com",
+		},
+		{
+			name:             "build override",
+			defaultAudience:  "https://vault.example.com",
+			allowOverride:    true,
+			buildAudience:    "sigstore",
+			expectedAudience: "sigstore",
+		},
+		{
+			name:             "build override not allowed",
+			defaultAudience:  "https://vault.example.com",
+			buildAudience:    "sigstore",
+			expectedAudience: "https://vault.example.com",
+		},
+		{
+			name:          "build audience without a default",
+			buildAudience: "sigstore",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			strategy := CustomBuildStrategy{
+				DefaultTokenAudience:       tc.defaultAudience,
+				AllowTokenAudienceOverride: tc.allowOverride,
+			}
+			build := mockCustomBuild(false, false)
+			if len(tc.buildAudience) > 0 {
+				build.Annotations = map[string]string{tokenAudienceAnnotation: tc.buildAudience}
+			}
+			pod, err := strategy.CreateBuildPod(build, nil, testInternalRegistryHost)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var projection *corev1.ServiceAccountTokenProjection
+			for _, v := range pod.Spec.Volumes {
+				if v.Projected != nil && len(v.Projected.Sources) > 0 {
+					projection = v.Projected.Sources[0].ServiceAccountToken
+				}
+			}
+			_, hasEnv := findEnvVar(pod.Spec.Containers[0].Env, "BUILD_TOKEN_PATH")
+			if len(tc.expectedAudience) == 0 {
+				if projection != nil || hasEnv {
+					t.Errorf("expected no service account token projection")
+				}
+				return
+			}
+			if projection == nil {
+				t.Fatalf("expected a service account token projection")
+			}
+			if projection.Audience != tc.expectedAudience {
+				t.Errorf("expected audience %q, got %q", tc.expectedAudience, projection.Audience)
+			}
+			if !hasEnv {
+				t.Errorf("expected BUILD_TOKEN_PATH to be set")
+			}
+		})
+	}
+}
+
+func TestCustomCreateBuildPodAllowedRegistries(t *testing.T) {
+	strategy := CustomBuildStrategy{
+		AllowedRegistries: []string{"quay.io"},
+		RegistryMirrors:   []string{"mirror.example.com:5000"},
+	}
+
+	tests := []struct {
+		name      string
+		image     string
+		expectErr bool
+	}{
+		{
+			name:  "allowed registry",
+			image: "quay.io/openshift/custom-builder:latest",
+		},
+		{
+			name:  "mirror",
+			image: "mirror.example.com:5000/openshift/custom-builder:latest",
+		},
+		{
+			name:  "internal registry",
+			image: testInternalRegistryHost + "/openshift/custom-builder:latest",
+		},
+		{
+			name:      "disallowed registry",
+			image:     "registry.example.com/openshift/custom-builder:latest",
+			expectErr: true,
+		},
+		{
+			name:      "docker hub",
+			image:     "custom-builder",
+			expectErr: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			build := mockCustomBuild(false, false)
+			build.Spec.Strategy.CustomStrategy.From.Name = tc.image
+			_, err := strategy.CreateBuildPod(build, nil, testInternalRegistryHost)
+			if tc.expectErr {
+				if !IsFatal(err) {
+					t.Errorf("expected fatal error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestCustomCreateBuildPodTerminationGracePeriod(t *testing.T) {
+	strategy := CustomBuildStrategy{
+		TerminationGracePeriodSecondsPerGi: 30,
+		MaxTerminationGracePeriodSeconds:   600,
+	}
+
+	tests := []struct {
+		name     string
+		size     string
+		expected int64
+	}{
+		{
+			name:     "no size",
+			expected: 30,
+		},
+		{
+			name:     "image smaller than 1Gi",
+			size:     "500Mi",
+			expected: 30,
+		},
+		{
+			name:     "negative size",
+			size:     "-4Gi",
+			expected: 30,
+		},
+		{
+			name:     "huge image",
+			size:     "1Ei",
+			expected: 600,
+		},
+		{
+			name:     "large image",
+			size:     "4Gi",
+			expected: 150,
+		},
+		{
+			name:     "bounded",
+			size:     "100Gi",
+			expected: 600,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			build := mockCustomBuild(false, false)
+			if len(tc.size) > 0 {
+				build.Annotations = map[string]string{imageSizeAnnotation: tc.size}
+			}
+			pod, err := strategy.CreateBuildPod(build, nil, testInternalRegistryHost)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if pod.Spec.TerminationGracePeriodSeconds == nil {
+				t.Fatalf("expected terminationGracePeriodSeconds to be set")
+			}
+			if *pod.Spec.TerminationGracePeriodSeconds != tc.expected {
+				t.Errorf("expected terminationGracePeriodSeconds %d, got %d", tc.expected, *pod.Spec.TerminationGracePeriodSeconds)
+			}
+		})
+	}
+
+	unbounded := CustomBuildStrategy{TerminationGracePeriodSecondsPerGi: 1 << 40}
+	build := mockCustomBuild(false, false)
+	build.Annotations = map[string]string{imageSizeAnnotation: "1Ei"}
+	pod, err := unbounded.CreateBuildPod(build, nil, testInternalRegistryHost)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if grace := pod.Spec.TerminationGracePeriodSeconds; grace == nil || *grace <= 0 {
+		t.Errorf("expected a positive terminationGracePeriodSeconds, got %v", grace)
+	}
+
+	pod, err = (&CustomBuildStrategy{}).CreateBuildPod(mockCustomBuild(false, false), nil, testInternalRegistryHost)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if pod.Spec.TerminationGracePeriodSeconds != nil {
+		t.Errorf("expected default terminationGracePeriodSeconds, got %d", *pod.Spec.TerminationGracePeriodSeconds)
+	}
+}
+
+func TestCustomCreateBuildPodCPULimit(t *testing.T) {
+	strategy := CustomBuildStrategy{}
+
+	tests := []struct {
+		name     string
+		limits   corev1.ResourceList
+		defaults corev1.ResourceList
+		env      []corev1.EnvVar
+		expected string
+	}{
+		{
+			name:     "whole cores",
+			limits:   corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("4")},
+			expected: "4",
+		},
+		{
+			name:     "fractional cores",
+			limits:   corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("2500m")},
+			expected: "3",
+		},
+		{
+			name:     "limit set by build defaults",
+			limits:   corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("1Gi")},
+			defaults: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("2")},
+			expected: "2",
+		},
+		{
+			name:     "set in the build environment",
+			limits:   corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("4")},
+			env:      []corev1.EnvVar{{Name: "BUILD_CPU_LIMIT", Value: "1"}},
+			expected: "1",
+		},
+		{
+			name:   "no cpu limit",
+			limits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("1Gi")},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			build := mockCustomBuild(false, false)
+			build.Spec.Resources.Limits = tc.limits
+			build.Spec.Strategy.CustomStrategy.Env = append(build.Spec.Strategy.CustomStrategy.Env, tc.env...)
+			pod, err := strategy.CreateBuildPod(build, nil, testInternalRegistryHost)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for name, quantity := range tc.defaults {
+				pod.Spec.Containers[0].Resources.Limits[name] = quantity
+			}
+			if err := strategy.FinalizeBuildPod(build, pod); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			env, ok := findEnvVar(pod.Spec.Containers[0].Env, "BUILD_CPU_LIMIT")
+			if len(tc.expected) == 0 {
+				if ok {
+					t.Errorf("expected BUILD_CPU_LIMIT to be omitted, got %q", env.Value)
+				}
+				return
+			}
+			if env.Value != tc.expected {
+				t.Errorf("expected BUILD_CPU_LIMIT %q, got %q", tc.expected, env.Value)
+			}
+		})
+	}
+}
+
+func TestCustomCreateBuildPodInputSourcesAsEnvFrom(t *testing.T) {
+	strategy := CustomBuildStrategy{InputSourcesAsEnvFrom: true}
+
+	build := mockCustomBuild(false, false)
+	build.Spec.Source.Secrets = []buildv1.SecretBuildSource{
+		{Secret: corev1.LocalObjectReference{Name: "build-secret"}},
+	}
+	build.Spec.Source.ConfigMaps = []buildv1.ConfigMapBuildSource{
+		{ConfigMap: corev1.LocalObjectReference{Name: "build-config"}},
+	}
+	pod, err := strategy.CreateBuildPod(build, nil, testInternalRegistryHost)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []corev1.EnvFromSource{
+		{SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "build-secret"}}},
+		{ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "build-config"}}},
+	}
+	if !reflect.DeepEqual(expected, pod.Spec.Containers[0].EnvFrom) {
+		t.Errorf("expected envFrom %#v, got %#v", expected, pod.Spec.Containers[0].EnvFrom)
+	}
+	for _, m := range pod.Spec.Containers[0].VolumeMounts {
+		if strings.HasPrefix(m.MountPath, SecretBuildSourceBaseMountPath) {
+			t.Errorf("expected build secret not to be mounted, found mount %s", m.MountPath)
+		}
+	}
+
+	build.Spec.Strategy.CustomStrategy.Env = append(build.Spec.Strategy.CustomStrategy.Env, corev1.EnvVar{
+		Name: "TOKEN",
+		ValueFrom: &corev1.EnvVarSource{
+			SecretKeyRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: "build-secret"},
+				Key:                  "token",
+			},
+		},
+	})
+	if _, err := strategy.CreateBuildPod(build, nil, testInternalRegistryHost); !IsFatal(err) {
+		t.Errorf("expected fatal error for duplicated secret reference, got %v", err)
+	}
+}
+
+func TestCustomCreateBuildPodNoSource(t *testing.T) {
+	strategy := CustomBuildStrategy{}
+
+	tests := []struct {
+		name        string
+		emptySource bool
+		binary      bool
+		expected    bool
+	}{
+		{
+			name: "git source",
+		},
+		{
+			name:        "binary source",
+			emptySource: true,
+			binary:      true,
+		},
+		{
+			name:        "no source",
+			emptySource: true,
+			expected:    true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			build := mockCustomBuild(false, tc.emptySource)
+			if tc.binary {
+				build.Spec.Source.Binary = &buildv1.BinaryBuildSource{}
+			}
+			pod, err := strategy.CreateBuildPod(build, nil, testInternalRegistryHost)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			env, ok := findEnvVar(pod.Spec.Containers[0].Env, "BUILD_SOURCE_NONE")
+			if ok != tc.expected {
+				t.Fatalf("expected BUILD_SOURCE_NONE present=%v, got %v", tc.expected, ok)
+			}
+			if ok && env.Value != "true" {
+				t.Errorf("expected BUILD_SOURCE_NONE to be true, got %q", env.Value)
+			}
+		})
+	}
+}
+
+func TestCustomCreateBuildPodDetached(t *testing.T) {
+	tests := []struct {
+		name             string
+		allowDetached    bool
+		annotations      map[string]string
+		expectedOwnerRef bool
+	}{
+		{
+			name:             "default",
+			allowDetached:    true,
+			expectedOwnerRef: true,
+		},
+		{
+			name:          "detached",
+			allowDetached: true,
+			annotations:   map[string]string{detachedPodAnnotation: "true"},
+		},
+		{
+			name:             "detached pods not allowed",
+			annotations:      map[string]string{detachedPodAnnotation: "true"},
+			expectedOwnerRef: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			strategy := CustomBuildStrategy{AllowDetachedPods: tc.allowDetached}
+			build := mockCustomBuild(false, false)
+			build.UID = "build-uid"
+			build.Annotations = tc.annotations
+			pod, err := strategy.CreateBuildPod(build, nil, testInternalRegistryHost)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if HasOwnerReference(pod, build) != tc.expectedOwnerRef {
+				t.Errorf("expected owner reference present=%v, got %v", tc.expectedOwnerRef, pod.OwnerReferences)
+			}
+			if !IsPodOfBuild(pod, build) {
+				t.Errorf("expected the pod to be recognized as the pod of the build")
+			}
+			other := build.DeepCopy()
+			other.UID = "other-build-uid"
+			if IsPodOfBuild(pod, other) {
+				t.Errorf("expected the pod not to be recognized as the pod of another build")
+			}
+		})
+	}
+}
+
+func TestCustomCreateBuildPodResolvConf(t *testing.T) {
+	strategy := CustomBuildStrategy{
+		ResolvConf: &corev1.ConfigMapKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: "dns"},
+			Key:                  "resolv.conf",
+		},
+	}
+	pod, err := strategy.CreateBuildPod(mockCustomBuild(false, false), nil, testInternalRegistryHost)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var mount *corev1.VolumeMount
+	for i, m := range pod.Spec.Containers[0].VolumeMounts {
+		if m.MountPath == "/etc/resolv.conf" {
+			mount = &pod.Spec.Containers[0].VolumeMounts[i]
+		}
+	}
+	if mount == nil {
+		t.Fatalf("expected /etc/resolv.conf to be mounted")
+	}
+	if mount.SubPath != "resolv.conf" || !mount.ReadOnly {
+		t.Errorf("unexpected resolv.conf mount %#v", mount)
+	}
+	for _, v := range pod.Spec.Volumes {
+		if v.Name != mount.Name {
+			continue
+		}
+		if v.ConfigMap == nil || v.ConfigMap.Name != "dns" || len(v.ConfigMap.Items) != 1 || v.ConfigMap.Items[0].Key != "resolv.conf" {
+			t.Errorf("unexpected resolv.conf volume %#v", v)
+		}
+	}
+
+	pod.Spec.DNSPolicy = corev1.DNSNone
+	pod.Spec.DNSConfig = &corev1.PodDNSConfig{Nameservers: []string{"10.0.0.10"}}
+	if err := strategy.FinalizeBuildPod(mockCustomBuild(false, false), pod); !IsFatal(err) {
+		t.Errorf("expected fatal error for a pod using the None DNS policy, got %v", err)
+	}
+
+	indexer := newIndexer(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "dns", Namespace: "builds"},
+		Data:       map[string]string{"nameservers": "nameserver 10.0.0.10"},
+	})
+	strategy.ConfigMapLister = v1lister.NewConfigMapLister(indexer)
+	build := mockCustomBuild(false, false)
+	build.Namespace = "builds"
+	if _, err := strategy.CreateBuildPod(build, nil, testInternalRegistryHost); !IsFatal(err) {
+		t.Errorf("expected fatal error for a key missing from the ConfigMap, got %v", err)
+	}
+
+	strategy.ResolvConf.Key = ""
+	if _, err := strategy.CreateBuildPod(mockCustomBuild(false, false), nil, testInternalRegistryHost); !IsFatal(err) {
+		t.Errorf("expected fatal error for missing key, got %v", err)
+	}
+}
+
+func TestCustomCreateBuildPodAttemptAnnotation(t *testing.T) {
+	strategy := CustomBuildStrategy{}
+
+	pod, err := strategy.CreateBuildPod(mockCustomBuild(false, false), nil, testInternalRegistryHost)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if attempt, ok := pod.Annotations[BuildAttemptAnnotation]; !ok || attempt != "1" {
+		t.Errorf("expected %s to be seeded to 1, got %q", BuildAttemptAnnotation, attempt)
+	}
+
+	build := mockCustomBuild(false, false)
+	build.Annotations = map[string]string{BuildAttemptAnnotation: "3"}
+	pod, err = strategy.CreateBuildPod(build, nil, testInternalRegistryHost)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if attempt := pod.Annotations[BuildAttemptAnnotation]; attempt != "3" {
+		t.Errorf("expected %s to be carried over from the build, got %q", BuildAttemptAnnotation, attempt)
+	}
+}
+
+func TestCustomCreateBuildPodEntrypointWrapper(t *testing.T) {
+	strategy := CustomBuildStrategy{
+		EntrypointWrapper: &corev1.ConfigMapKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: "wrapper"},
+			Key:                  "wrap.sh",
+		},
+		EntrypointWrapperCommand: []string{"/usr/bin/build", "--verbose"},
+	}
+	pod, err := strategy.CreateBuildPod(mockCustomBuild(false, false), nil, testInternalRegistryHost)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	container := pod.Spec.Containers[0]
+	expectedCommand := []string{filepath.Join(ConfigMapEntrypointMountPath, "entrypoint")}
+	if !reflect.DeepEqual(expectedCommand, container.Command) {
+		t.Errorf("expected command %v, got %v", expectedCommand, container.Command)
+	}
+	if expected := []string{"/usr/bin/build", "--verbose"}; !reflect.DeepEqual(expected, container.Args) {
+		t.Errorf("expected the builder entrypoint %v as args, got %v", expected, container.Args)
+	}
+	found := false
+	for _, m := range container.VolumeMounts {
+		if m.MountPath == ConfigMapEntrypointMountPath {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected the entrypoint wrapper to be mounted at %s", ConfigMapEntrypointMountPath)
+	}
+	for _, v := range pod.Spec.Volumes {
+		if v.Name != "build-entrypoint-wrapper" {
+			continue
+		}
+		if v.ConfigMap == nil || v.ConfigMap.Items[0].Key != "wrap.sh" || *v.ConfigMap.DefaultMode != 0o755 {
+			t.Errorf("unexpected entrypoint wrapper volume %#v", v)
+		}
+	}
+
+	strategy.EntrypointWrapperCommand = nil
+	if _, err := strategy.CreateBuildPod(mockCustomBuild(false, false), nil, testInternalRegistryHost); !IsFatal(err) {
+		t.Errorf("expected fatal error for missing entrypoint command, got %v", err)
+	}
+
+	strategy.EntrypointWrapperCommand = []string{"/usr/bin/build"}
+	indexer := newIndexer(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "wrapper", Namespace: "builds"},
+		Data:       map[string]string{"entrypoint.sh": "#!/bin/sh\nexec \"$@\""},
+	})
+	strategy.ConfigMapLister = v1lister.NewConfigMapLister(indexer)
+	build := mockCustomBuild(false, false)
+	build.Namespace = "builds"
+	if _, err := strategy.CreateBuildPod(build, nil, testInternalRegistryHost); !IsFatal(err) {
+		t.Errorf("expected fatal error for a key missing from the ConfigMap, got %v", err)
+	}
+	strategy.ConfigMapLister = nil
+
+	strategy.EntrypointWrapper.Key = ""
+	if _, err := strategy.CreateBuildPod(mockCustomBuild(false, false), nil, testInternalRegistryHost); !IsFatal(err) {
+		t.Errorf("expected fatal error for missing key, got %v", err)
+	}
+}
+
+func TestCustomCreateBuildPodQOSClassAnnotation(t *testing.T) {
+	strategy := CustomBuildStrategy{}
+
+	tests := []struct {
+		name      string
+		resources corev1.ResourceRequirements
+		defaults  corev1.ResourceList
+		expected  corev1.PodQOSClass
+	}{
+		{
+			name: "guaranteed",
+			resources: corev1.ResourceRequirements{
+				Limits: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse("1"),
+					corev1.ResourceMemory: resource.MustParse("1Gi"),
+				},
+			},
+			expected: corev1.PodQOSGuaranteed,
+		},
+		{
+			name: "burstable",
+			resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse("500m"),
+					corev1.ResourceMemory: resource.MustParse("512Mi"),
+				},
+				Limits: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse("1"),
+					corev1.ResourceMemory: resource.MustParse("1Gi"),
+				},
+			},
+			expected: corev1.PodQOSBurstable,
+		},
+		{
+			name:     "best effort",
+			expected: corev1.PodQOSBestEffort,
+		},
+		{
+			name: "guaranteed by build defaults",
+			defaults: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("1"),
+				corev1.ResourceMemory: resource.MustParse("1Gi"),
+			},
+			expected: corev1.PodQOSGuaranteed,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			build := mockCustomBuild(false, false)
+			build.Spec.Resources = tc.resources
+			pod, err := strategy.CreateBuildPod(build, nil, testInternalRegistryHost)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			// simulate the limits added by build defaults before the pod is finalized
+			for name, limit := range tc.defaults {
+				if pod.Spec.Containers[0].Resources.Limits == nil {
+					pod.Spec.Containers[0].Resources.Limits = corev1.ResourceList{}
+				}
+				pod.Spec.Containers[0].Resources.Limits[name] = limit
+			}
+			if err := strategy.FinalizeBuildPod(build, pod); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if qos := pod.Annotations[QOSClassAnnotation]; qos != string(tc.expected) {
+				t.Errorf("expected QoS class %s, got %s", tc.expected, qos)
+			}
+		})
+	}
+}
+
+func TestCustomCreateBuildPodNodeCATrust(t *testing.T) {
+	for _, enabled := range []bool{true, false} {
+		strategy := CustomBuildStrategy{MountNodeCATrust: enabled}
+		pod, err := strategy.CreateBuildPod(mockCustomBuild(false, false), nil, testInternalRegistryHost)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var hostPath *corev1.HostPathVolumeSource
+		for _, v := range pod.Spec.Volumes {
+			if v.HostPath != nil && v.HostPath.Path == "/etc/pki" {
+				hostPath = v.HostPath
+			}
+		}
+		mounted := false
+		for _, m := range pod.Spec.Containers[0].VolumeMounts {
+			if m.MountPath == NodeCATrustMountPath {
+				mounted = true
+				if !m.ReadOnly {
+					t.Errorf("expected node CA trust to be mounted read-only")
+				}
+			}
+		}
+		if (hostPath != nil) != enabled || mounted != enabled {
+			t.Errorf("expected node CA trust mounted=%v, got volume=%v mount=%v", enabled, hostPath != nil, mounted)
+		}
+	}
+}
+
+func TestCustomCreateBuildPodCorrelationLabel(t *testing.T) {
+	strategy := CustomBuildStrategy{
+		CorrelationAnnotation: "pipelines.example.com/run-id",
+		CorrelationLabel:      "pipelines.example.com/run",
+	}
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		expected    string
+	}{
+		{
+			name:        "correlation annotation",
+			annotations: map[string]string{"pipelines.example.com/run-id": "argo/run:1234"},
+			expected:    "argo-run-1234",
+		},
+		{
+			name: "missing annotation",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			build := mockCustomBuild(false, false)
+			build.Annotations = tc.annotations
+			pod, err := strategy.CreateBuildPod(build, nil, testInternalRegistryHost)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			value, ok := pod.Labels["pipelines.example.com/run"]
+			if len(tc.expected) == 0 {
+				if ok {
+					t.Errorf("expected correlation label to be omitted, got %q", value)
+				}
+				return
+			}
+			if value != tc.expected {
+				t.Errorf("expected correlation label %q, got %q", tc.expected, value)
+			}
+		})
+	}
+}
+
+func TestCustomCreateBuildPodBuildCache(t *testing.T) {
+	strategy := CustomBuildStrategy{
+		BuildCacheURL:    "https://cache.example.com/builds",
+		BuildCacheSecret: &corev1.LocalObjectReference{Name: "cache-credentials"},
+	}
+	pod, err := strategy.CreateBuildPod(mockCustomBuild(false, false), nil, testInternalRegistryHost)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	container := pod.Spec.Containers[0]
+	if env, _ := findEnvVar(container.Env, "BUILD_CACHE_URL"); env.Value != strategy.BuildCacheURL {
+		t.Errorf("expected BUILD_CACHE_URL %q, got %q", strategy.BuildCacheURL, env.Value)
+	}
+	if env, _ := findEnvVar(container.Env, "BUILD_CACHE_CREDENTIALS_PATH"); env.Value != BuildCacheSecretMountPath {
+		t.Errorf("expected BUILD_CACHE_CREDENTIALS_PATH %q, got %q", BuildCacheSecretMountPath, env.Value)
+	}
+	mounted := false
+	for _, m := range container.VolumeMounts {
+		if m.MountPath == BuildCacheSecretMountPath {
+			mounted = true
+		}
+	}
+	if !mounted {
+		t.Errorf("expected build cache credentials to be mounted at %s", BuildCacheSecretMountPath)
+	}
+
+	strategy.BuildCacheURL = "://cache"
+	if _, err := strategy.CreateBuildPod(mockCustomBuild(false, false), nil, testInternalRegistryHost); !IsFatal(err) {
+		t.Errorf("expected fatal error for invalid cache URL, got %v", err)
+	}
+}
+
+func TestCustomCreateBuildPodSecurityPostureAnnotation(t *testing.T) {
+	strategy := CustomBuildStrategy{}
+
+	tests := []struct {
+		name         string
+		privileged   bool
+		dockerSocket bool
+		expected     string
+	}{
+		{
+			name:         "privileged with docker socket",
+			privileged:   true,
+			dockerSocket: true,
+			expected:     "privileged=true,runAsNonRoot=false,readOnlyRootFilesystem=false,dockerSocket=true",
+		},
+		{
+			name:     "unprivileged",
+			expected: "privileged=false,runAsNonRoot=false,readOnlyRootFilesystem=false,dockerSocket=false",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			build := mockCustomBuild(false, false)
+			build.Spec.Strategy.CustomStrategy.ExposeDockerSocket = tc.dockerSocket
+			build.Spec.Strategy.CustomStrategy.Env = append(build.Spec.Strategy.CustomStrategy.Env, corev1.EnvVar{Name: "BUILD_PRIVILEGED", Value: strconv.FormatBool(tc.privileged)})
+			pod, err := strategy.CreateBuildPod(build, nil, testInternalRegistryHost)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if posture := pod.Annotations[SecurityPostureAnnotation]; posture != tc.expected {
+				t.Errorf("expected security posture %q, got %q", tc.expected, posture)
+			}
+		})
+	}
+}
+
+func TestCustomCreateBuildPodGitConfig(t *testing.T) {
+	strategy := CustomBuildStrategy{
+		GitConfig: &corev1.ConfigMapKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: "git"},
+			Key:                  "gitconfig",
+		},
+	}
+
+	tests := []struct {
+		name     string
+		home     string
+		expected string
+	}{
+		{
+			name:     "default home",
+			expected: "/root/.gitconfig",
+		},
+		{
+			name:     "home from build env",
+			home:     "/home/builder",
+			expected: "/home/builder/.gitconfig",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			build := mockCustomBuild(false, false)
+			if len(tc.home) > 0 {
+				build.Spec.Strategy.CustomStrategy.Env = append(build.Spec.Strategy.CustomStrategy.Env, corev1.EnvVar{Name: "HOME", Value: tc.home})
+			}
+			pod, err := strategy.CreateBuildPod(build, nil, testInternalRegistryHost)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			container := pod.Spec.Containers[0]
+			if env, _ := findEnvVar(container.Env, "GIT_CONFIG_GLOBAL"); env.Value != tc.expected {
+				t.Errorf("expected GIT_CONFIG_GLOBAL %q, got %q", tc.expected, env.Value)
+			}
+			mounted := false
+			for _, m := range container.VolumeMounts {
+				if m.MountPath == tc.expected && m.SubPath == ".gitconfig" {
+					mounted = true
+				}
+			}
+			if !mounted {
+				t.Errorf("expected git config to be mounted at %s", tc.expected)
+			}
+		})
+	}
+
+	indexer := newIndexer(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "git", Namespace: "builds"},
+		Data:       map[string]string{"config": "[http]\n\tsslVerify = true"},
+	})
+	strategy.ConfigMapLister = v1lister.NewConfigMapLister(indexer)
+	build := mockCustomBuild(false, false)
+	build.Namespace = "builds"
+	if _, err := strategy.CreateBuildPod(build, nil, testInternalRegistryHost); !IsFatal(err) {
+		t.Errorf("expected fatal error for a key missing from the ConfigMap, got %v", err)
+	}
+	strategy.ConfigMapLister = nil
+
+	strategy.GitConfig.Key = ""
+	if _, err := strategy.CreateBuildPod(mockCustomBuild(false, false), nil, testInternalRegistryHost); !IsFatal(err) {
+		t.Errorf("expected fatal error for missing key, got %v", err)
+	}
+}
+
+func TestCustomCreateBuildPodOutputImageLabel(t *testing.T) {
+	const label = "openshift.io/build.output-image"
+	strategy := CustomBuildStrategy{OutputImageLabel: label}
+
+	tests := []struct {
+		name     string
+		output   *corev1.ObjectReference
+		expected string
+	}{
+		{
+			name:     "tag reference",
+			output:   &corev1.ObjectReference{Kind: "DockerImage", Name: "quay.io/myorg/app:v1.2"},
+			expected: "myorg-app",
+		},
+		{
+			name:     "digest reference",
+			output:   &corev1.ObjectReference{Kind: "DockerImage", Name: "quay.io/myorg/app@sha256:0000000000000000000000000000000000000000000000000000000000000000"},
+			expected: "myorg-app",
+		},
+		{
+			name:     "no registry",
+			output:   &corev1.ObjectReference{Kind: "DockerImage", Name: "app:latest"},
+			expected: "app",
+		},
+		{
+			name: "no output",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			build := mockCustomBuild(false, false)
+			build.Spec.Output.To = tc.output
+			pod, err := strategy.CreateBuildPod(build, nil, testInternalRegistryHost)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			value, ok := pod.Labels[label]
+			if len(tc.expected) == 0 {
+				if ok {
+					t.Errorf("expected output image label to be omitted, got %q", value)
+				}
+				return
+			}
+			if value != tc.expected {
+				t.Errorf("expected output image label %q, got %q", tc.expected, value)
+			}
+		})
+	}
+}
+
+func TestCustomCreateBuildPodRequireCompletionDeadline(t *testing.T) {
+	zero := int64(0)
+	tests := []struct {
+		name      string
+		require   bool
+		deadline  *int64
+		expectErr bool
+	}{
+		{
+			name:      "missing deadline rejected",
+			require:   true,
+			expectErr: true,
+		},
+		{
+			name:      "zero deadline rejected",
+			require:   true,
+			deadline:  &zero,
+			expectErr: true,
+		},
+		{
+			name:    "policy disabled",
+			require: false,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			strategy := CustomBuildStrategy{RequireCompletionDeadline: tc.require}
+			build := mockCustomBuild(false, false)
+			build.Spec.CompletionDeadlineSeconds = tc.deadline
+			_, err := strategy.CreateBuildPod(build, nil, testInternalRegistryHost)
+			if tc.expectErr != IsFatal(err) {
+				t.Errorf("expected fatal error=%v, got %v", tc.expectErr, err)
+			}
+		})
+	}
+
+	strategy := CustomBuildStrategy{RequireCompletionDeadline: true}
+	if _, err := strategy.CreateBuildPod(mockCustomBuild(false, false), nil, testInternalRegistryHost); err != nil {
+		t.Errorf("unexpected error for build with a deadline: %v", err)
+	}
+}
+
+func TestCustomCreateBuildPodRegistryAuthFile(t *testing.T) {
+	tests := []struct {
+		name       string
+		envVar     string
+		pushSecret *corev1.LocalObjectReference
+		pullSecret *corev1.LocalObjectReference
+		expected   string
+	}{
+		{
+			name:       "push secret",
+			pushSecret: &corev1.LocalObjectReference{Name: "push"},
+			pullSecret: &corev1.LocalObjectReference{Name: "pull"},
+			expected:   filepath.Join(DockerPushSecretMountPath, corev1.DockerConfigJsonKey),
+		},
+		{
+			name:       "pull secret",
+			pullSecret: &corev1.LocalObjectReference{Name: "pull"},
+			expected:   filepath.Join(DockerPullSecretMountPath, corev1.DockerConfigJsonKey),
+		},
+		{
+			name:       "configured name",
+			envVar:     "BUILDAH_AUTH_FILE",
+			pushSecret: &corev1.LocalObjectReference{Name: "push"},
+			expected:   filepath.Join(DockerPushSecretMountPath, corev1.DockerConfigJsonKey),
+		},
+		{
+			name:       "dockercfg push secret",
+			pushSecret: &corev1.LocalObjectReference{Name: "legacy"},
+			pullSecret: &corev1.LocalObjectReference{Name: "pull"},
+		},
+		{
+			name:       "unknown secret",
+			pushSecret: &corev1.LocalObjectReference{Name: "missing"},
+		},
+		{
+			name: "no secrets",
+		},
+	}
+	indexer := newIndexer(
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "builds", Name: "push"}, Type: corev1.SecretTypeDockerConfigJson},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "builds", Name: "pull"}, Type: corev1.SecretTypeDockerConfigJson},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "builds", Name: "legacy"}, Type: corev1.SecretTypeDockercfg},
+	)
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			strategy := CustomBuildStrategy{
+				RegistryAuthFileEnvVar: tc.envVar,
+				SecretLister:           v1lister.NewSecretLister(indexer),
+			}
+			build := mockCustomBuild(false, false)
+			build.Namespace = "builds"
+			build.Spec.Output.PushSecret = tc.pushSecret
+			build.Spec.Strategy.CustomStrategy.PullSecret = tc.pullSecret
+			pod, err := strategy.CreateBuildPod(build, nil, testInternalRegistryHost)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			name := tc.envVar
+			if len(name) == 0 {
+				name = "REGISTRY_AUTH_FILE"
+			}
+			env, ok := findEnvVar(pod.Spec.Containers[0].Env, name)
+			if len(tc.expected) == 0 {
+				if ok {
+					t.Errorf("expected %s to be omitted, got %q", name, env.Value)
+				}
+				return
+			}
+			if env.Value != tc.expected {
+				t.Errorf("expected %s %q, got %q", name, tc.expected, env.Value)
+			}
+		})
+	}
+
+	strategy := CustomBuildStrategy{}
+	pod, err := strategy.CreateBuildPod(mockCustomBuild(false, false), nil, testInternalRegistryHost)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if env, ok := findEnvVar(pod.Spec.Containers[0].Env, "REGISTRY_AUTH_FILE"); ok {
+		t.Errorf("expected REGISTRY_AUTH_FILE to be omitted without a secret lister, got %q", env.Value)
+	}
+}
+
+func TestCustomCreateBuildPodOverlappingImagePaths(t *testing.T) {
+	strategy := CustomBuildStrategy{RejectOverlappingImagePaths: true}
+
+	tests := []struct {
+		name      string
+		dirs      []string
+		expectErr bool
+	}{
+		{
+			name: "non-overlapping paths",
+			dirs: []string{"app", "application", "lib"},
+		},
+		{
+			name:      "nested paths",
+			dirs:      []string{"app", "app/lib"},
+			expectErr: true,
+		},
+		{
+			name:      "same path",
+			dirs:      []string{"app/", "./app"},
+			expectErr: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			build := mockCustomBuild(false, false)
+			for i, dir := range tc.dirs {
+				build.Spec.Source.Images = append(build.Spec.Source.Images, buildv1.ImageSource{
+					From:  corev1.ObjectReference{Kind: "DockerImage", Name: fmt.Sprintf("image-%d", i)},
+					Paths: []buildv1.ImageSourcePath{{SourcePath: "/opt/content", DestinationDir: dir}},
+				})
+			}
+			_, err := strategy.CreateBuildPod(build, nil, testInternalRegistryHost)
+			if tc.expectErr != IsFatal(err) {
+				t.Errorf("expected fatal error=%v, got %v", tc.expectErr, err)
+			}
+		})
+	}
+}
+
+func TestCustomCreateBuildPodEnvOrder(t *testing.T) {
+	strategy := CustomBuildStrategy{}
+
+	env := []corev1.EnvVar{
+		{Name: "ZONE", Value: "us-east"},
+		{Name: "REGION", Value: "$(ZONE)-1"},
+		{Name: "BUCKET", Value: "artifacts-$(REGION)"},
+		{Name: "ARTIFACT_URL", Value: "s3://$(BUCKET)/builds"},
+	}
+	build := mockCustomBuild(false, false)
+	build.Spec.Strategy.CustomStrategy.Env = env
+	pod, err := strategy.CreateBuildPod(build, nil, testInternalRegistryHost)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var actual []corev1.EnvVar
+	for _, e := range pod.Spec.Containers[0].Env {
+		for _, expected := range env {
+			if e.Name == expected.Name {
+				actual = append(actual, e)
+			}
+		}
+	}
+	if !reflect.DeepEqual(env, actual) {
+		t.Errorf("expected env order %v, got %v", env, actual)
+	}
+}
+
+func TestCustomCreateBuildPodBuildNumber(t *testing.T) {
+	strategy := CustomBuildStrategy{}
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		expected    string
+	}{
+		{
+			name:        "valid number",
+			annotations: map[string]string{buildv1.BuildNumberAnnotation: "42"},
+			expected:    "42",
+		},
+		{
+			name: "missing annotation",
+		},
+		{
+			name:        "non-numeric value",
+			annotations: map[string]string{buildv1.BuildNumberAnnotation: "forty-two"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			build := mockCustomBuild(false, false)
+			build.Annotations = tc.annotations
+			pod, err := strategy.CreateBuildPod(build, nil, testInternalRegistryHost)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			env, ok := findEnvVar(pod.Spec.Containers[0].Env, "BUILD_NUMBER")
+			if len(tc.expected) == 0 {
+				if ok {
+					t.Errorf("expected BUILD_NUMBER to be omitted, got %q", env.Value)
+				}
+				return
+			}
+			if env.Value != tc.expected {
+				t.Errorf("expected BUILD_NUMBER %q, got %q", tc.expected, env.Value)
+			}
+		})
+	}
+}
+
+func TestCustomCreateBuildPodSecretsDir(t *testing.T) {
+	strategy := CustomBuildStrategy{SecretsDir: "/var/run/secrets/build"}
+
+	build := mockCustomBuild(false, false)
+	build.Spec.Source.Secrets = []buildv1.SecretBuildSource{
+		{Secret: corev1.LocalObjectReference{Name: "input"}},
+	}
+	pod, err := strategy.CreateBuildPod(build, nil, testInternalRegistryHost)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	container := pod.Spec.Containers[0]
+	for _, name := range []string{"input", "secret"} {
+		expected := filepath.Join(strategy.SecretsDir, name)
+		found := false
+		for _, m := range container.VolumeMounts {
+			if m.MountPath == expected {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("expected secret %q to be mounted at %s, got %#v", name, expected, container.VolumeMounts)
+		}
+	}
+	env, ok := findEnvVar(container.Env, "BUILD_SECRETS_DIR")
+	if !ok || env.Value != strategy.SecretsDir {
+		t.Errorf("expected BUILD_SECRETS_DIR %q, got %#v", strategy.SecretsDir, env)
+	}
+
+	build.Spec.Source.Secrets = append(build.Spec.Source.Secrets, buildv1.SecretBuildSource{
+		Secret: corev1.LocalObjectReference{Name: "secret"},
+	})
+	if _, err := strategy.CreateBuildPod(build, nil, testInternalRegistryHost); !IsFatal(err) {
+		t.Errorf("expected a fatal error for colliding secrets, got %v", err)
+	}
+}
+
+func TestCustomCreateBuildPodDisallowedImageTags(t *testing.T) {
+	strategy := CustomBuildStrategy{DisallowedImageTags: []string{"latest"}}
+
+	tests := []struct {
+		name      string
+		image     string
+		expectErr bool
+	}{
+		{
+			name:      "latest tag",
+			image:     "quay.io/openshift/custom-builder:latest",
+			expectErr: true,
+		},
+		{
+			name:      "implicit latest tag",
+			image:     "quay.io/openshift/custom-builder",
+			expectErr: true,
+		},
+		{
+			name:  "pinned tag",
+			image: "quay.io/openshift/custom-builder:v4.12.1",
+		},
+		{
+			name:  "digest",
+			image: "quay.io/openshift/custom-builder@sha256:4c3e84a8c1b2a0c1e1e0f1f3a3c4d1e2b6f8a9c0d1e2f3a4b5c6d7e8f9a0b1c2",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			build := mockCustomBuild(false, false)
+			build.Spec.Strategy.CustomStrategy.From.Name = tc.image
+			_, err := strategy.CreateBuildPod(build, nil, testInternalRegistryHost)
+			if tc.expectErr {
+				if !IsFatal(err) {
+					t.Errorf("expected fatal error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestCustomCreateBuildPodNodeSelectorPolicy(t *testing.T) {
+	indexer := newIndexer(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "build-node-selectors", Namespace: "openshift-config"},
+		Data: map[string]string{
+			"team-a": "node-role.kubernetes.io/builds=team-a,node=builder",
+		},
+	})
+	strategy := CustomBuildStrategy{
+		NodeSelectorPolicyNamespace: "openshift-config",
+		NodeSelectorPolicyName:      "build-node-selectors",
+		ConfigMapLister:             v1lister.NewConfigMapLister(indexer),
+	}
+
+	tests := []struct {
+		name      string
+		namespace string
+		expected  map[string]string
+	}{
+		{
+			name:      "namespace with policy",
+			namespace: "team-a",
+			expected: map[string]string{
+				"node-role.kubernetes.io/builds": "team-a",
+				"node":                           "builder",
+			},
+		},
+		{
+			name:      "namespace without policy",
+			namespace: "team-b",
+			expected:  map[string]string{"node": "mynode"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			build := mockCustomBuild(false, false)
+			build.Namespace = tc.namespace
+			pod, err := strategy.CreateBuildPod(build, nil, testInternalRegistryHost)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(tc.expected, pod.Spec.NodeSelector) {
+				t.Errorf("expected node selector %v, got %v", tc.expected, pod.Spec.NodeSelector)
+			}
+			if expected := (buildv1.OptionalNodeSelector{"node": "mynode"}); !reflect.DeepEqual(expected, build.Spec.NodeSelector) {
+				t.Errorf("expected the build node selector to be unchanged, got %v", build.Spec.NodeSelector)
+			}
+		})
+	}
+}
+
+func TestCustomCreateBuildPodNodeSelectorPolicyWithoutLister(t *testing.T) {
+	strategy := CustomBuildStrategy{
+		NodeSelectorPolicyNamespace: "openshift-config",
+		NodeSelectorPolicyName:      "build-node-selectors",
+	}
+	if _, err := strategy.CreateBuildPod(mockCustomBuild(false, false), nil, testInternalRegistryHost); err == nil {
+		t.Errorf("expected an error for a node selector policy without a ConfigMap lister")
+	}
+}
+
+func TestCustomCreateBuildPodFeatureFlags(t *testing.T) {
+	tests := []struct {
+		name      string
+		flags     []corev1.EnvVar
+		buildEnv  []corev1.EnvVar
+		expected  map[string]string
+		expectErr bool
+	}{
+		{
+			name: "flags applied",
+			flags: []corev1.EnvVar{
+				{Name: "BUILD_FEATURE_SBOM", Value: "true"},
+				{Name: "BUILD_FEATURE_ZSTD", Value: "false"},
+			},
+			expected: map[string]string{
+				"BUILD_FEATURE_SBOM": "true",
+				"BUILD_FEATURE_ZSTD": "false",
+			},
+		},
+		{
+			name: "per build override",
+			flags: []corev1.EnvVar{
+				{Name: "BUILD_FEATURE_SBOM", Value: "true"},
+			},
+			buildEnv: []corev1.EnvVar{
+				{Name: "BUILD_FEATURE_SBOM", Value: "false"},
+			},
+			expected: map[string]string{
+				"BUILD_FEATURE_SBOM": "false",
+			},
+		},
+		{
+			name: "missing prefix",
+			flags: []corev1.EnvVar{
+				{Name: "SBOM", Value: "true"},
+			},
+			expectErr: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			strategy := CustomBuildStrategy{FeatureFlags: tc.flags}
+			build := mockCustomBuild(false, false)
+			build.Spec.Strategy.CustomStrategy.Env = append(build.Spec.Strategy.CustomStrategy.Env, tc.buildEnv...)
+			pod, err := strategy.CreateBuildPod(build, nil, testInternalRegistryHost)
+			if tc.expectErr {
+				if !IsFatal(err) {
+					t.Errorf("expected fatal error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			actual := map[string]string{}
+			for _, env := range pod.Spec.Containers[0].Env {
+				if !strings.HasPrefix(env.Name, "BUILD_FEATURE_") {
+					continue
+				}
+				if _, ok := actual[env.Name]; ok {
+					t.Errorf("feature flag %s is set more than once", env.Name)
+				}
+				actual[env.Name] = env.Value
+			}
+			if !reflect.DeepEqual(tc.expected, actual) {
+				t.Errorf("expected feature flags %v, got %v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestCustomCreateBuildPodSecretMountPaths(t *testing.T) {
+	strategy := CustomBuildStrategy{AllowedSecretMountPrefixes: []string{"/var/run/secrets/custom"}}
+
+	tests := []struct {
+		name       string
+		mountPaths []string
+		expectErr  bool
+	}{
+		{
+			name:       "valid mounts",
+			mountPaths: []string{"/var/run/secrets/custom/foo", "/var/run/secrets/custom/bar"},
+		},
+		{
+			name:       "colliding mounts",
+			mountPaths: []string{"/var/run/secrets/custom/foo", "/var/run/secrets/custom/foo/"},
+			expectErr:  true,
+		},
+		{
+			name:       "nested mounts",
+			mountPaths: []string{"/var/run/secrets/custom/foo", "/var/run/secrets/custom/foo/bar"},
+			expectErr:  true,
+		},
+		{
+			name:       "relative mount",
+			mountPaths: []string{"secret"},
+			expectErr:  true,
+		},
+		{
+			name:       "out of prefix mount",
+			mountPaths: []string{"/etc/pki"},
+			expectErr:  true,
+		},
+		{
+			name:       "prefix escape",
+			mountPaths: []string{"/var/run/secrets/custom/../token"},
+			expectErr:  true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			build := mockCustomBuild(false, false)
+			build.Spec.Strategy.CustomStrategy.Secrets = nil
+			for i, p := range tc.mountPaths {
+				build.Spec.Strategy.CustomStrategy.Secrets = append(build.Spec.Strategy.CustomStrategy.Secrets, buildv1.SecretSpec{
+					SecretSource: corev1.LocalObjectReference{Name: fmt.Sprintf("secret-%d", i)},
+					MountPath:    p,
+				})
+			}
+			_, err := strategy.CreateBuildPod(build, nil, testInternalRegistryHost)
+			if tc.expectErr {
+				if !IsFatal(err) {
+					t.Errorf("expected fatal error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestCustomCreateBuildPodTraceImageLabel(t *testing.T) {
+	strategy := CustomBuildStrategy{
+		TraceAnnotation: "example.com/traceparent",
+		TraceImageLabel: "io.opentelemetry.trace",
+	}
+	traceparent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		expected    []buildv1.ImageLabel
+	}{
+		{
+			name:        "traced build",
+			annotations: map[string]string{"example.com/traceparent": traceparent},
+			expected: []buildv1.ImageLabel{
+				{Name: "version", Value: "1.0"},
+				{Name: "io.opentelemetry.trace", Value: traceparent},
+			},
+		},
+		{
+			name: "untraced build",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			build := mockCustomBuild(false, false)
+			build.Annotations = tc.annotations
+			build.Spec.Output.ImageLabels = []buildv1.ImageLabel{{Name: "version", Value: "1.0"}}
+			pod, err := strategy.CreateBuildPod(build, nil, testInternalRegistryHost)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			env, ok := findEnvVar(pod.Spec.Containers[0].Env, "BUILD_IMAGE_LABELS")
+			if tc.expected == nil {
+				if ok {
+					t.Errorf("expected BUILD_IMAGE_LABELS to be omitted, got %q", env.Value)
+				}
+				return
+			}
+			var actual []buildv1.ImageLabel
+			if err := json.Unmarshal([]byte(env.Value), &actual); err != nil {
+				t.Fatalf("failed to decode BUILD_IMAGE_LABELS %q: %v", env.Value, err)
+			}
+			if !reflect.DeepEqual(tc.expected, actual) {
+				t.Errorf("expected image labels %v, got %v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestCustomCreateBuildPodMaxImageSources(t *testing.T) {
+	tests := []struct {
+		name      string
+		max       int
+		images    int
+		expectErr bool
+	}{
+		{
+			name:   "under the limit",
+			max:    3,
+			images: 3,
+		},
+		{
+			name:      "over the limit",
+			max:       3,
+			images:    4,
+			expectErr: true,
+		},
+		{
+			name:      "over the default limit",
+			images:    defaultMaxImageSources + 1,
+			expectErr: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			strategy := CustomBuildStrategy{MaxImageSources: tc.max}
+			build := mockCustomBuild(false, false)
+			for i := 0; i < tc.images; i++ {
+				build.Spec.Source.Images = append(build.Spec.Source.Images, buildv1.ImageSource{
+					From:  corev1.ObjectReference{Kind: "DockerImage", Name: fmt.Sprintf("image-%d", i)},
+					Paths: []buildv1.ImageSourcePath{{SourcePath: "/opt/content", DestinationDir: fmt.Sprintf("dir-%d", i)}},
+				})
+			}
+			_, err := strategy.CreateBuildPod(build, nil, testInternalRegistryHost)
+			if tc.expectErr != IsFatal(err) {
+				t.Errorf("expected fatal error=%v, got %v", tc.expectErr, err)
+			}
+			if tc.expectErr && err != nil && !strings.Contains(err.Error(), strconv.Itoa(tc.images)) {
+				t.Errorf("expected the error to report %d input images, got %v", tc.images, err)
+			}
+		})
+	}
+}
+
+func TestCustomCreateBuildPodBuildCreated(t *testing.T) {
+	created := time.Date(2023, time.March, 14, 15, 9, 26, 0, time.UTC)
+	now := time.Date(2024, time.January, 2, 3, 4, 5, 0, time.UTC)
+	strategy := CustomBuildStrategy{Clock: clocktesting.NewFakePassiveClock(now)}
+
+	tests := []struct {
+		name     string
+		created  time.Time
+		expected string
+	}{
+		{
+			name:     "creation timestamp",
+			created:  created,
+			expected: "2023-03-14T15:09:26Z",
+		},
+		{
+			name:     "clock fallback",
+			expected: "2024-01-02T03:04:05Z",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			build := mockCustomBuild(false, false)
+			build.CreationTimestamp = metav1.NewTime(tc.created)
+			pod, err := strategy.CreateBuildPod(build, nil, testInternalRegistryHost)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			env, ok := findEnvVar(pod.Spec.Containers[0].Env, "BUILD_CREATED")
+			if !ok || env.Value != tc.expected {
+				t.Errorf("expected BUILD_CREATED %q, got %#v", tc.expected, env)
+			}
+		})
+	}
+}
+
+func TestCustomCreateBuildPodGPGKeyring(t *testing.T) {
+	strategy := CustomBuildStrategy{GPGKeyringSecret: &corev1.LocalObjectReference{Name: "signing-keys"}}
+	pod, err := strategy.CreateBuildPod(mockCustomBuild(false, false), nil, testInternalRegistryHost)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	container := pod.Spec.Containers[0]
+	if env, _ := findEnvVar(container.Env, "GNUPGHOME"); env.Value != GPGHomeMountPath {
+		t.Errorf("expected GNUPGHOME %q, got %q", GPGHomeMountPath, env.Value)
+	}
+	if env, _ := findEnvVar(container.Env, "BUILD_GPG_KEYRING_DIR"); env.Value != GPGKeyringMountPath {
+		t.Errorf("expected BUILD_GPG_KEYRING_DIR %q, got %q", GPGKeyringMountPath, env.Value)
+	}
+	var keyringMount, homeMount *corev1.VolumeMount
+	for i, m := range container.VolumeMounts {
+		switch m.MountPath {
+		case GPGKeyringMountPath:
+			keyringMount = &container.VolumeMounts[i]
+		case GPGHomeMountPath:
+			homeMount = &container.VolumeMounts[i]
+		}
+	}
+	if keyringMount == nil {
+		t.Fatalf("expected the GPG keyring to be mounted at %s", GPGKeyringMountPath)
+	}
+	if !keyringMount.ReadOnly {
+		t.Errorf("expected the GPG keyring to be mounted read-only")
+	}
+	if homeMount == nil {
+		t.Fatalf("expected GNUPGHOME to be mounted at %s", GPGHomeMountPath)
+	}
+	if homeMount.ReadOnly {
+		t.Errorf("expected GNUPGHOME to be writable")
+	}
+	keyringFound, homeFound := false, false
+	for _, v := range pod.Spec.Volumes {
+		if v.Name == keyringMount.Name && v.Secret != nil && v.Secret.SecretName == "signing-keys" {
+			keyringFound = true
+		}
+		if v.Name == homeMount.Name && v.EmptyDir != nil {
+			homeFound = true
+		}
+	}
+	if !keyringFound {
+		t.Errorf("expected volume %s to reference secret signing-keys", keyringMount.Name)
+	}
+	if !homeFound {
+		t.Errorf("expected volume %s to be an emptyDir", homeMount.Name)
+	}
+
+	strategy.GPGKeyringSecret = &corev1.LocalObjectReference{}
+	if _, err := strategy.CreateBuildPod(mockCustomBuild(false, false), nil, testInternalRegistryHost); !IsFatal(err) {
+		t.Errorf("expected fatal error for an unnamed GPG keyring secret, got %v", err)
+	}
+}
+
+func TestCustomCreateBuildPodPrivilegedCompletionDeadline(t *testing.T) {
+	strategy := CustomBuildStrategy{RequirePrivilegedCompletionDeadline: true}
+	deadline := int64(3600)
+
+	tests := []struct {
+		name         string
+		dockerSocket bool
+		deadline     *int64
+		expectErr    bool
+	}{
+		{
+			name:         "privileged build with deadline",
+			dockerSocket: true,
+			deadline:     &deadline,
+		},
+		{
+			name:         "privileged build without deadline",
+			dockerSocket: true,
+			expectErr:    true,
+		},
+		{
+			name: "non-privileged build without deadline",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			build := mockCustomBuild(false, false)
+			build.Spec.Strategy.CustomStrategy.ExposeDockerSocket = tc.dockerSocket
+			build.Spec.CompletionDeadlineSeconds = tc.deadline
+			_, err := strategy.CreateBuildPod(build, nil, testInternalRegistryHost)
+			if tc.expectErr != IsFatal(err) {
+				t.Errorf("expected fatal error=%v, got %v", tc.expectErr, err)
+			}
+		})
+	}
+}
+
+func TestCustomCreateBuildPodDeadlineWatcher(t *testing.T) {
+	strategy := CustomBuildStrategy{DeadlineWatcherImage: "quay.io/openshift/deadline-watcher:v1"}
+
+	build := mockCustomBuild(false, false)
+	pod, err := strategy.CreateBuildPod(build, nil, testInternalRegistryHost)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(pod.Spec.Containers) != 2 {
+		t.Fatalf("expected 2 containers, got %d", len(pod.Spec.Containers))
+	}
+	if pod.Spec.Containers[0].Name != CustomBuild {
+		t.Errorf("expected the builder to be the first container, got %s", pod.Spec.Containers[0].Name)
+	}
+	sidecar := pod.Spec.Containers[1]
+	if sidecar.Name != DeadlineWatcherContainer || sidecar.Image != strategy.DeadlineWatcherImage {
+		t.Errorf("unexpected sidecar %s with image %s", sidecar.Name, sidecar.Image)
+	}
+	if pod.Spec.ShareProcessNamespace == nil || !*pod.Spec.ShareProcessNamespace {
+		t.Errorf("expected the pod to share its process namespace")
+	}
+	if !kapihelper.Semantic.DeepEqual(sidecar.Resources.Requests, deadlineWatcherResources) || !kapihelper.Semantic.DeepEqual(sidecar.Resources.Limits, deadlineWatcherResources) {
+		t.Errorf("expected the sidecar to request and be limited to %v, got %#v", deadlineWatcherResources, sidecar.Resources)
+	}
+	sc := sidecar.SecurityContext
+	if sc == nil || sc.Privileged != nil || sc.AllowPrivilegeEscalation == nil || *sc.AllowPrivilegeEscalation ||
+		sc.Capabilities == nil || !reflect.DeepEqual(sc.Capabilities.Add, []corev1.Capability{"KILL"}) {
+		t.Errorf("unexpected sidecar security context %#v", sc)
+	}
+	expected := strconv.FormatInt(*build.Spec.CompletionDeadlineSeconds, 10)
+	if env, _ := findEnvVar(sidecar.Env, "BUILD_DEADLINE_SECONDS"); env.Value != expected {
+		t.Errorf("expected BUILD_DEADLINE_SECONDS %q, got %q", expected, env.Value)
+	}
+
+	strategy.DeadlineWatcherImage = ""
+	pod, err = strategy.CreateBuildPod(build, nil, testInternalRegistryHost)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(pod.Spec.Containers) != 1 || pod.Spec.ShareProcessNamespace != nil {
+		t.Errorf("expected no deadline watcher, got %d containers", len(pod.Spec.Containers))
+	}
+}
+
+func TestCustomCreateBuildPodCacheAffinity(t *testing.T) {
+	strategy := CustomBuildStrategy{CacheKeyLabel: "example.com/cache-key"}
+
+	build := mockCustomBuild(false, false)
+	build.Labels["example.com/cache-key"] = "maven-deps"
+	pod, err := strategy.CreateBuildPod(build, nil, testInternalRegistryHost)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if pod.Labels["example.com/cache-key"] != "maven-deps" {
+		t.Errorf("expected the cache key label on the pod, got %v", pod.Labels)
+	}
+	if pod.Spec.Affinity == nil || pod.Spec.Affinity.PodAffinity == nil {
+		t.Fatalf("expected a pod affinity, got %#v", pod.Spec.Affinity)
+	}
+	terms := pod.Spec.Affinity.PodAffinity.PreferredDuringSchedulingIgnoredDuringExecution
+	if len(terms) != 1 {
+		t.Fatalf("expected 1 preferred pod affinity term, got %d", len(terms))
+	}
+	term := terms[0].PodAffinityTerm
+	expected := map[string]string{"example.com/cache-key": "maven-deps"}
+	if term.LabelSelector == nil || !reflect.DeepEqual(expected, term.LabelSelector.MatchLabels) {
+		t.Errorf("expected the affinity term to select %v, got %#v", expected, term.LabelSelector)
+	}
+	if term.TopologyKey != corev1.LabelHostname {
+		t.Errorf("expected topology key %s, got %s", corev1.LabelHostname, term.TopologyKey)
+	}
+
+	delete(build.Labels, "example.com/cache-key")
+	pod, err = strategy.CreateBuildPod(build, nil, testInternalRegistryHost)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if pod.Spec.Affinity != nil {
+		t.Errorf("expected no affinity for a build without a cache key, got %#v", pod.Spec.Affinity)
+	}
+}
+
+func TestCustomCreateBuildPodReservedEnv(t *testing.T) {
+	tests := []struct {
+		name      string
+		env       corev1.EnvVar
+		allow     bool
+		build     func(*buildv1.Build)
+		expectErr bool
+	}{
+		{
+			name:      "BUILD",
+			env:       corev1.EnvVar{Name: "BUILD", Value: "{}"},
+			expectErr: true,
+		},
+		{
+			name:      "injected variable",
+			env:       corev1.EnvVar{Name: "SOURCE_REPOSITORY", Value: "https://example.com/repo.git"},
+			expectErr: true,
+		},
+		{
+			name:  "allowed override",
+			env:   corev1.EnvVar{Name: "BUILD", Value: "{}"},
+			allow: true,
+		},
+		{
+			name: "overridable variable",
+			env:  corev1.EnvVar{Name: "LANG", Value: "en_US.utf8"},
+		},
+		{
+			name: "non-colliding variable",
+			env:  corev1.EnvVar{Name: "BUILD_LOGLEVEL", Value: "5"},
+		},
+		{
+			name: "build hint",
+			env:  corev1.EnvVar{Name: "BUILD_NUMBER", Value: "7"},
+		},
+		{
+			name:      "variable set after the build environment",
+			env:       corev1.EnvVar{Name: "DOCKER_SOCKET", Value: "/tmp/docker.sock"},
+			expectErr: true,
+		},
+		{
+			name: "variable the controller does not set",
+			env:  corev1.EnvVar{Name: "DOCKER_SOCKET", Value: "/tmp/docker.sock"},
+			build: func(build *buildv1.Build) {
+				build.Spec.Strategy.CustomStrategy.ExposeDockerSocket = false
+			},
+		},
+		{
+			name: "storage options set by the build",
+			env:  corev1.EnvVar{Name: "STORAGE_OPTS", Value: "overlay.mount_program=/usr/bin/fuse-overlayfs"},
+		},
+		{
+			name: "registry auth file set by the build",
+			env:  corev1.EnvVar{Name: "REGISTRY_AUTH_FILE", Value: "/tmp/auth.json"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			strategy := CustomBuildStrategy{AllowReservedEnvOverrides: tc.allow}
+			build := mockCustomBuild(false, false)
+			build.Spec.Strategy.CustomStrategy.Env = append(build.Spec.Strategy.CustomStrategy.Env, tc.env)
+			if build.Annotations == nil {
+				build.Annotations = map[string]string{}
+			}
+			build.Annotations[buildv1.BuildNumberAnnotation] = "3"
+			if tc.build != nil {
+				tc.build(build)
+			}
+			pod, err := strategy.CreateBuildPod(build, nil, testInternalRegistryHost)
+			if tc.expectErr != IsFatal(err) {
+				t.Fatalf("expected fatal error=%v, got %v", tc.expectErr, err)
+			}
+			if err != nil {
+				return
+			}
+			// the last definition of a variable takes precedence
+			var last corev1.EnvVar
+			for _, env := range pod.Spec.Containers[0].Env {
+				if env.Name == tc.env.Name {
+					last = env
+				}
+			}
+			if last != tc.env {
+				t.Errorf("expected the build environment %v to take precedence, got %v", tc.env, last)
+			}
+		})
+	}
+}
+
+func TestCustomCreateBuildPodPushConcurrency(t *testing.T) {
+	tests := []struct {
+		name        string
+		concurrency int
+		expected    string
+		expectErr   bool
+	}{
+		{
+			name:        "configured",
+			concurrency: 8,
+			expected:    "8",
+		},
+		{
+			name:     "default",
+			expected: strconv.Itoa(defaultPushConcurrency),
+		},
+		{
+			name:        "invalid",
+			concurrency: -1,
+			expectErr:   true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			strategy := CustomBuildStrategy{PushConcurrency: tc.concurrency}
+			pod, err := strategy.CreateBuildPod(mockCustomBuild(false, false), nil, testInternalRegistryHost)
+			if tc.expectErr {
+				if !IsFatal(err) {
+					t.Errorf("expected fatal error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if env, _ := findEnvVar(pod.Spec.Containers[0].Env, "BUILD_PUSH_CONCURRENCY"); env.Value != tc.expected {
+				t.Errorf("expected BUILD_PUSH_CONCURRENCY %q, got %q", tc.expected, env.Value)
+			}
+		})
+	}
+}
+
+func TestCustomCreateBuildPodDockerSocketPathOverride(t *testing.T) {
+	strategy := CustomBuildStrategy{AllowDockerSocketPathOverride: true}
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		expected    string
+		expectErr   bool
+	}{
+		{
+			name:        "override",
+			annotations: map[string]string{dockerSocketPathAnnotation: "/run/podman/podman.sock"},
+			expected:    "/run/podman/podman.sock",
+		},
+		{
+			name:     "default",
+			expected: dockerSocketPath,
+		},
+		{
+			name:        "relative path",
+			annotations: map[string]string{dockerSocketPathAnnotation: "run/podman/podman.sock"},
+			expectErr:   true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			build := mockCustomBuild(false, false)
+			build.Annotations = tc.annotations
+			pod, err := strategy.CreateBuildPod(build, nil, testInternalRegistryHost)
+			if tc.expectErr {
+				if !IsFatal(err) {
+					t.Errorf("expected fatal error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			container := pod.Spec.Containers[0]
+			if env, _ := findEnvVar(container.Env, "DOCKER_SOCKET"); env.Value != tc.expected {
+				t.Errorf("expected DOCKER_SOCKET %q, got %q", tc.expected, env.Value)
+			}
+			for _, v := range pod.Spec.Volumes {
+				if v.Name != "docker-socket" {
+					continue
+				}
+				if v.HostPath == nil || v.HostPath.Path != tc.expected {
+					t.Errorf("expected the docker socket volume to use host path %q, got %#v", tc.expected, v.HostPath)
+				} else if v.HostPath.Type == nil || *v.HostPath.Type != corev1.HostPathSocket {
+					t.Errorf("expected the docker socket host path to have type %q, got %v", corev1.HostPathSocket, v.HostPath.Type)
+				}
+			}
+			for _, m := range container.VolumeMounts {
+				if m.Name == "docker-socket" && m.MountPath != tc.expected {
+					t.Errorf("expected the docker socket to be mounted at %q, got %q", tc.expected, m.MountPath)
+				}
+			}
+		})
+	}
+}
+
+func TestCustomCreateBuildPodEnvironment(t *testing.T) {
+	indexer := newIndexer(
+		&corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{Name: "team-a-prod", Labels: map[string]string{"example.com/environment": "prod"}},
+		},
+		&corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{Name: "team-a-sandbox"},
+		},
+	)
+	strategy := CustomBuildStrategy{
+		EnvironmentLabel: "example.com/environment",
+		NamespaceLister:  v1lister.NewNamespaceLister(indexer),
+	}
+
+	tests := []struct {
+		name      string
+		namespace string
+		expected  string
+	}{
+		{
+			name:      "labeled namespace",
+			namespace: "team-a-prod",
+			expected:  "prod",
+		},
+		{
+			name:      "unlabeled namespace",
+			namespace: "team-a-sandbox",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			build := mockCustomBuild(false, false)
+			build.Namespace = tc.namespace
+			pod, err := strategy.CreateBuildPod(build, nil, testInternalRegistryHost)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			env, ok := findEnvVar(pod.Spec.Containers[0].Env, "BUILD_ENVIRONMENT")
+			label, labeled := pod.Labels["example.com/environment"]
+			if len(tc.expected) == 0 {
+				if ok || labeled {
+					t.Errorf("expected no environment, got env %q and label %q", env.Value, label)
+				}
+				return
+			}
+			if env.Value != tc.expected {
+				t.Errorf("expected BUILD_ENVIRONMENT %q, got %q", tc.expected, env.Value)
+			}
+			if label != tc.expected {
+				t.Errorf("expected environment label %q, got %q", tc.expected, label)
+			}
+		})
+	}
+}
+
+func TestCustomCreateBuildPodEnvironmentWithoutLister(t *testing.T) {
+	strategy := CustomBuildStrategy{EnvironmentLabel: "example.com/environment"}
+	if _, err := strategy.CreateBuildPod(mockCustomBuild(false, false), nil, testInternalRegistryHost); err == nil {
+		t.Errorf("expected an error for an environment label without a namespace lister")
+	}
+}
+
+func TestCustomCreateBuildPodRequireResourceLimits(t *testing.T) {
+	tests := []struct {
+		name      string
+		require   bool
+		limits    corev1.ResourceList
+		defaults  corev1.ResourceList
+		expectErr string
+	}{
+		{
+			name:    "fully limited build",
+			require: true,
+			limits: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("1"),
+				corev1.ResourceMemory: resource.MustParse("1Gi"),
+			},
+		},
+		{
+			name:    "missing memory limit",
+			require: true,
+			limits: corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse("1"),
+			},
+			expectErr: "memory",
+		},
+		{
+			name:    "limits from build defaults",
+			require: true,
+			defaults: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("1"),
+				corev1.ResourceMemory: resource.MustParse("1Gi"),
+			},
+		},
+		{
+			name: "policy disabled",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			strategy := CustomBuildStrategy{RequireResourceLimits: tc.require}
+			build := mockCustomBuild(false, false)
+			build.Spec.Resources = corev1.ResourceRequirements{Limits: tc.limits}
+			pod, err := strategy.CreateBuildPod(build, nil, testInternalRegistryHost)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			// simulate the limits added by build defaults before the pod is finalized
+			for name, limit := range tc.defaults {
+				if pod.Spec.Containers[0].Resources.Limits == nil {
+					pod.Spec.Containers[0].Resources.Limits = corev1.ResourceList{}
+				}
+				pod.Spec.Containers[0].Resources.Limits[name] = limit
+			}
+			err = strategy.FinalizeBuildPod(build, pod)
+			if len(tc.expectErr) > 0 {
+				if !IsFatal(err) || !strings.Contains(err.Error(), tc.expectErr) {
+					t.Errorf("expected fatal error naming the %s limit, got %v", tc.expectErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestCustomCreateBuildPodRegistriesConf(t *testing.T) {
+	indexer := newIndexer(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "registries", Namespace: "builds"},
+		Data:       map[string]string{"registries.conf": "unqualified-search-registries = [\"quay.io\"]"},
+	})
+	lister := v1lister.NewConfigMapLister(indexer)
+
+	tests := []struct {
+		name      string
+		key       string
+		expectErr bool
+	}{
+		{
+			name: "existing key",
+			key:  "registries.conf",
+		},
+		{
+			name:      "missing key",
+			key:       "mirrors.conf",
+			expectErr: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			strategy := CustomBuildStrategy{
+				RegistriesConf: &corev1.ConfigMapKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "registries"},
+					Key:                  tc.key,
+				},
+				ConfigMapLister: lister,
+			}
+			build := mockCustomBuild(false, false)
+			build.Namespace = "builds"
+			pod, err := strategy.CreateBuildPod(build, nil, testInternalRegistryHost)
+			if tc.expectErr {
+				if !IsFatal(err) {
+					t.Errorf("expected fatal error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			container := pod.Spec.Containers[0]
+			if env, _ := findEnvVar(container.Env, "CONTAINERS_REGISTRIES_CONF"); env.Value != ContainersRegistriesConfPath {
+				t.Errorf("expected CONTAINERS_REGISTRIES_CONF %q, got %q", ContainersRegistriesConfPath, env.Value)
+			}
+			var mount *corev1.VolumeMount
+			for i, m := range container.VolumeMounts {
+				if m.MountPath == ContainersRegistriesConfPath {
+					mount = &container.VolumeMounts[i]
+				}
+			}
+			if mount == nil || mount.SubPath != "registries.conf" || !mount.ReadOnly {
+				t.Fatalf("expected registries.conf to be mounted read-only at %s, got %#v", ContainersRegistriesConfPath, mount)
+			}
+			for _, v := range pod.Spec.Volumes {
+				if v.Name != mount.Name {
+					continue
+				}
+				if v.ConfigMap == nil || v.ConfigMap.Name != "registries" || len(v.ConfigMap.Items) != 1 || v.ConfigMap.Items[0].Key != tc.key {
+					t.Errorf("unexpected registries.conf volume %#v", v.VolumeSource)
+				}
+			}
+		})
+	}
+}
+
+func TestCustomCreateBuildPodSourceDateEpoch(t *testing.T) {
+	strategy := CustomBuildStrategy{SourceDateEpoch: true}
+	created := time.Date(2023, time.March, 14, 15, 9, 26, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		commit      string
+		annotations map[string]string
+		created     time.Time
+		expected    string
+	}{
+		{
+			name:        "commit timestamp",
+			commit:      "4c3e84a8",
+			annotations: map[string]string{CommitDateAnnotation: "2023-01-02T03:04:05Z"},
+			created:     created,
+			expected:    "1672628645",
+		},
+		{
+			name:     "creation timestamp",
+			created:  created,
+			expected: "1678806566",
+		},
+		{
+			name:        "commit date without commit",
+			annotations: map[string]string{CommitDateAnnotation: "2023-01-02T03:04:05Z"},
+			created:     created,
+			expected:    "1678806566",
+		},
+		{
+			name: "omitted",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			build := mockCustomBuild(false, false)
+			build.Annotations = tc.annotations
+			build.CreationTimestamp = metav1.NewTime(tc.created)
+			build.Spec.Revision.Git.Commit = tc.commit
+			pod, err := strategy.CreateBuildPod(build, nil, testInternalRegistryHost)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			env, ok := findEnvVar(pod.Spec.Containers[0].Env, "SOURCE_DATE_EPOCH")
+			if len(tc.expected) == 0 {
+				if ok {
+					t.Errorf("expected SOURCE_DATE_EPOCH to be omitted, got %q", env.Value)
+				}
+				return
+			}
+			if env.Value != tc.expected {
+				t.Errorf("expected SOURCE_DATE_EPOCH %q, got %q", tc.expected, env.Value)
+			}
+		})
+	}
+}
+
+type fakeImageResolver struct {
+	digests map[string]string
+}
+
+func (r *fakeImageResolver) ResolveImageDigest(namespace, image string) (string, error) {
+	return r.digests[image], nil
+}
+
+func TestCustomCreateBuildPodBuilderImageDigest(t *testing.T) {
+	digest := "sha256:4c3e84a8c1b2a0c1e1e0f1f3a3c4d1e2b6f8a9c0d1e2f3a4b5c6d7e8f9a0b1c2"
+
+	tests := []struct {
+		name     string
+		resolver ImageResolver
+		expected string
+	}{
+		{
+			name:     "resolved",
+			resolver: &fakeImageResolver{digests: map[string]string{"builder-image": digest}},
+			expected: digest,
+		},
+		{
+			name:     "not resolved",
+			resolver: &fakeImageResolver{},
+		},
+		{
+			name: "no resolver",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			strategy := CustomBuildStrategy{ImageResolver: tc.resolver}
+			pod, err := strategy.CreateBuildPod(mockCustomBuild(false, false), nil, testInternalRegistryHost)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if pod.Spec.Containers[0].Image != "builder-image" {
+				t.Errorf("expected the builder image reference to be kept, got %s", pod.Spec.Containers[0].Image)
+			}
+			actual, ok := pod.Annotations[BuilderImageDigestAnnotation]
+			if len(tc.expected) == 0 {
+				if ok {
+					t.Errorf("expected no digest annotation, got %q", actual)
+				}
+				return
+			}
+			if actual != tc.expected {
+				t.Errorf("expected digest annotation %q, got %q", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestCustomCreateBuildPodQuotaLabel(t *testing.T) {
+	tests := []struct {
+		name     string
+		key      string
+		value    string
+		expected map[string]string
+	}{
+		{
+			name: "default label",
+			expected: map[string]string{
+				buildv1.BuildLabel: "CustomBuild",
+				"workload-type":    "build",
+			},
+		},
+		{
+			name:  "configured label",
+			key:   "example.com/quota-scope",
+			value: "builds",
+			expected: map[string]string{
+				buildv1.BuildLabel:        "CustomBuild",
+				"example.com/quota-scope": "builds",
+			},
+		},
+		{
+			name:  "reserved label",
+			key:   buildv1.BuildLabel,
+			value: "build",
+			expected: map[string]string{
+				buildv1.BuildLabel: "CustomBuild",
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			strategy := CustomBuildStrategy{SetQuotaLabel: true, QuotaLabelKey: tc.key, QuotaLabelValue: tc.value}
+			pod, err := strategy.CreateBuildPod(mockCustomBuild(false, false), nil, testInternalRegistryHost)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(tc.expected, pod.Labels) {
+				t.Errorf("expected pod labels %v, got %v", tc.expected, pod.Labels)
+			}
+		})
+	}
+}
+
+func TestCustomCreateBuildPodSecretPathConflicts(t *testing.T) {
+	strategy := CustomBuildStrategy{RejectSecretPathConflicts: true}
+
+	tests := []struct {
+		name      string
+		mountPath string
+		expectErr bool
+	}{
+		{
+			name:      "conflicting paths",
+			mountPath: "/var/run/secrets/custom/secret",
+			expectErr: true,
+		},
+		{
+			name:      "same path",
+			mountPath: filepath.Join(SecretBuildSourceBaseMountPath, "secret"),
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			build := mockCustomBuild(false, false)
+			build.Spec.Source.Secrets = []buildv1.SecretBuildSource{
+				{Secret: corev1.LocalObjectReference{Name: "secret"}},
+			}
+			build.Spec.Strategy.CustomStrategy.Secrets[0].MountPath = tc.mountPath
+			_, err := strategy.CreateBuildPod(build, nil, testInternalRegistryHost)
+			if tc.expectErr != IsFatal(err) {
+				t.Errorf("expected fatal error=%v, got %v", tc.expectErr, err)
+			}
+		})
+	}
+
+	build := mockCustomBuild(false, false)
+	build.Spec.Source.Secrets = []buildv1.SecretBuildSource{
+		{Secret: corev1.LocalObjectReference{Name: "other"}},
+	}
+	if _, err := strategy.CreateBuildPod(build, nil, testInternalRegistryHost); err != nil {
+		t.Errorf("unexpected error for distinct secrets: %v", err)
+	}
+}
+
+func TestCustomCreateBuildPodImageLabelsFromBuildLabels(t *testing.T) {
+	strategy := CustomBuildStrategy{ImageLabelsFromBuildLabels: []string{buildv1.BuildConfigLabel, "app", "team"}}
+
+	build := mockCustomBuild(false, false)
+	build.Labels = map[string]string{
+		buildv1.BuildConfigLabel: "frontend",
+		"app":                    "shop",
+		"tier":                   "web",
+	}
+	pod, err := strategy.CreateBuildPod(build, nil, testInternalRegistryHost)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	env, ok := findEnvVar(pod.Spec.Containers[0].Env, "BUILD_IMAGE_LABELS")
+	if !ok {
+		t.Fatalf("expected BUILD_IMAGE_LABELS to be set")
+	}
+	var actual []buildv1.ImageLabel
+	if err := json.Unmarshal([]byte(env.Value), &actual); err != nil {
+		t.Fatalf("failed to decode BUILD_IMAGE_LABELS %q: %v", env.Value, err)
+	}
+	expected := []buildv1.ImageLabel{
+		{Name: buildv1.BuildConfigLabel, Value: "frontend"},
+		{Name: "app", Value: "shop"},
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected image labels %v, got %v", expected, actual)
+	}
+}
+
+func TestCustomCreateBuildPodDefaultBuilderImage(t *testing.T) {
+	build := mockCustomBuild(false, false)
+	build.Spec.Strategy.CustomStrategy.From = corev1.ObjectReference{Kind: "DockerImage"}
+
+	strategy := CustomBuildStrategy{DefaultBuilderImage: "quay.io/openshift/custom-builder:v1"}
+	pod, err := strategy.CreateBuildPod(build, nil, testInternalRegistryHost)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if pod.Spec.Containers[0].Image != strategy.DefaultBuilderImage {
+		t.Errorf("expected the default builder image %s, got %s", strategy.DefaultBuilderImage, pod.Spec.Containers[0].Image)
+	}
+	env, _ := findEnvVar(pod.Spec.Containers[0].Env, "BUILD")
+	if !strings.Contains(env.Value, strategy.DefaultBuilderImage) {
+		t.Errorf("expected the encoded build to reference the default builder image, got %s", env.Value)
+	}
+	if len(build.Spec.Strategy.CustomStrategy.From.Name) != 0 {
+		t.Errorf("expected the build to be unchanged, got image %s", build.Spec.Strategy.CustomStrategy.From.Name)
+	}
+
+	strategy.DefaultBuilderImage = ""
+	if _, err := strategy.CreateBuildPod(build, nil, testInternalRegistryHost); err == nil {
+		t.Errorf("expected an error for a build without an image")
+	}
+}
+
+func TestCustomCreateBuildPodPullSecretAnnotation(t *testing.T) {
+	strategy := CustomBuildStrategy{AllowPullSecretAnnotation: true}
+
+	build := mockCustomBuild(false, false)
+	build.Annotations = map[string]string{pullSecretAnnotation: "quay-pull"}
+	pod, err := strategy.CreateBuildPod(build, nil, testInternalRegistryHost)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	container := pod.Spec.Containers[0]
+	if env, _ := findEnvVar(container.Env, "PULL_DOCKERCFG_PATH"); env.Value != DockerPullSecretMountPath {
+		t.Errorf("expected PULL_DOCKERCFG_PATH %q, got %q", DockerPullSecretMountPath, env.Value)
+	}
+	var volumeName string
+	for _, m := range container.VolumeMounts {
+		if m.MountPath == DockerPullSecretMountPath {
+			volumeName = m.Name
+		}
+	}
+	found := false
+	for _, v := range pod.Spec.Volumes {
+		if v.Name == volumeName && v.Secret != nil && v.Secret.SecretName == "quay-pull" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected secret quay-pull to be mounted at %s", DockerPullSecretMountPath)
+	}
+
+	build.Annotations[pullSecretAnnotation] = "Quay_Pull"
+	if _, err := strategy.CreateBuildPod(build, nil, testInternalRegistryHost); !IsFatal(err) {
+		t.Errorf("expected fatal error for an invalid pull secret name, got %v", err)
+	}
+}
+
+func TestCustomCreateBuildPodEnvFromConfigMap(t *testing.T) {
+	indexer := newIndexer(
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "build-settings", Namespace: "builds"},
+			Data:       map[string]string{"MAVEN_OPTS": "-Xmx1g", "GOFLAGS": "-mod=vendor"},
+		},
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "colliding-settings", Namespace: "builds"},
+			Data:       map[string]string{"BUILD": "{}"},
+		},
+	)
+
+	tests := []struct {
+		name      string
+		configMap string
+		reject    bool
+		expectErr bool
+	}{
+		{
+			name:      "envFrom reference",
+			configMap: "build-settings",
+			reject:    true,
+		},
+		{
+			name:      "rejected collision",
+			configMap: "colliding-settings",
+			reject:    true,
+			expectErr: true,
+		},
+		{
+			name:      "missing ConfigMap",
+			configMap: "missing-settings",
+			reject:    true,
+		},
+		{
+			name:      "explicit env wins",
+			configMap: "colliding-settings",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			strategy := CustomBuildStrategy{
+				EnvFromConfigMap:                 tc.configMap,
+				RejectEnvFromConfigMapCollisions: tc.reject,
+				ConfigMapLister:                  v1lister.NewConfigMapLister(indexer),
+			}
+			build := mockCustomBuild(false, false)
+			build.Namespace = "builds"
+			pod, err := strategy.CreateBuildPod(build, nil, testInternalRegistryHost)
+			if tc.expectErr {
+				if !IsFatal(err) {
+					t.Errorf("expected fatal error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			envFrom := pod.Spec.Containers[0].EnvFrom
+			if len(envFrom) != 1 || envFrom[0].ConfigMapRef == nil || envFrom[0].ConfigMapRef.Name != tc.configMap {
+				t.Fatalf("expected envFrom to reference ConfigMap %s, got %#v", tc.configMap, envFrom)
+			}
+			if optional := envFrom[0].ConfigMapRef.Optional; optional == nil || !*optional {
+				t.Errorf("expected the envFrom ConfigMap to be optional")
+			}
+			if _, ok := findEnvVar(pod.Spec.Containers[0].Env, "BUILD"); !ok {
+				t.Errorf("expected BUILD to be set explicitly")
+			}
+		})
+	}
+}
+
+func TestCustomCreateBuildPodEnvFromConfigMapWithoutLister(t *testing.T) {
+	strategy := CustomBuildStrategy{
+		EnvFromConfigMap:                 "build-env",
+		RejectEnvFromConfigMapCollisions: true,
+	}
+	if _, err := strategy.CreateBuildPod(mockCustomBuild(false, false), nil, testInternalRegistryHost); err == nil {
+		t.Errorf("expected an error for rejecting collisions without a ConfigMap lister")
+	}
+}
+
+func TestCustomCreateBuildPodEnvNamesAnnotation(t *testing.T) {
+	strategy := CustomBuildStrategy{AnnotateEnvNames: true}
+	build := mockCustomBuild(false, false)
+	pod, err := strategy.CreateBuildPod(build, nil, testInternalRegistryHost)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// simulate the proxy environment added by build defaults before the pod is finalized
+	pod.Spec.Containers[0].Env = append(pod.Spec.Containers[0].Env, corev1.EnvVar{Name: "HTTP_PROXY", Value: "http://proxy.example.com"})
+	if err := strategy.FinalizeBuildPod(build, pod); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	names := pod.Annotations[EnvNamesAnnotation]
+	expected := map[string]bool{}
+	for _, env := range pod.Spec.Containers[0].Env {
+		expected[env.Name] = true
+	}
+	actual := map[string]bool{}
+	for _, name := range strings.Split(names, ",") {
+		actual[name] = true
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected env names %v, got %q", expected, names)
+	}
+	if !actual["BUILD"] || !actual["HTTP_PROXY"] {
+		t.Errorf("expected env names to include BUILD and HTTP_PROXY, got %q", names)
+	}
+}
+
+func TestCustomCreateBuildPodRejectAmbiguousOutput(t *testing.T) {
+	strategy := CustomBuildStrategy{RejectAmbiguousOutput: true}
+
+	tests := []struct {
+		name      string
+		mutate    func(build *buildv1.Build)
+		expectErr bool
+	}{
+		{
+			name:   "single output",
+			mutate: func(build *buildv1.Build) {},
+		},
+		{
+			name: "no output",
+			mutate: func(build *buildv1.Build) {
+				build.Spec.Output = buildv1.BuildOutput{}
+			},
+		},
+		{
+			name: "push secret without output",
+			mutate: func(build *buildv1.Build) {
+				build.Spec.Output.To = nil
+			},
+			expectErr: true,
+		},
+		{
+			name: "output in environment",
+			mutate: func(build *buildv1.Build) {
+				build.Spec.Output = buildv1.BuildOutput{}
+				build.Spec.Strategy.CustomStrategy.Env = append(build.Spec.Strategy.CustomStrategy.Env, corev1.EnvVar{Name: "OUTPUT_IMAGE", Value: "quay.io/team/app:latest"})
+			},
+			expectErr: true,
+		},
+		{
+			name: "unresolved output",
+			mutate: func(build *buildv1.Build) {
+				build.Spec.Output.To = &corev1.ObjectReference{Kind: "ImageStreamTag", Name: "app:latest"}
+			},
+			expectErr: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			build := mockCustomBuild(false, false)
+			tc.mutate(build)
+			_, err := strategy.CreateBuildPod(build, nil, testInternalRegistryHost)
+			if tc.expectErr != IsFatal(err) {
+				t.Errorf("expected fatal error=%v, got %v", tc.expectErr, err)
+			}
+		})
+	}
+}
+
+func TestCustomCreateBuildPodLockToken(t *testing.T) {
+	strategy := CustomBuildStrategy{LockToken: "shared-db-lock"}
+	pod, err := strategy.CreateBuildPod(mockCustomBuild(false, false), nil, testInternalRegistryHost)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if env, _ := findEnvVar(pod.Spec.Containers[0].Env, "BUILD_LOCK_TOKEN"); env.Value != strategy.LockToken {
+		t.Errorf("expected BUILD_LOCK_TOKEN %q, got %q", strategy.LockToken, env.Value)
+	}
+
+	strategy = CustomBuildStrategy{GenerateLockToken: true}
+	tokens := sets.NewString()
+	for i := 0; i < 2; i++ {
+		pod, err := strategy.CreateBuildPod(mockCustomBuild(false, false), nil, testInternalRegistryHost)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		env, ok := findEnvVar(pod.Spec.Containers[0].Env, "BUILD_LOCK_TOKEN")
+		if !ok || len(env.Value) == 0 {
+			t.Fatalf("expected a generated BUILD_LOCK_TOKEN, got %#v", env)
+		}
+		tokens.Insert(env.Value)
+	}
+	if tokens.Len() != 2 {
+		t.Errorf("expected generated lock tokens to be unique, got %v", tokens.List())
+	}
+
+	strategy = CustomBuildStrategy{}
+	pod, err = strategy.CreateBuildPod(mockCustomBuild(false, false), nil, testInternalRegistryHost)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if env, ok := findEnvVar(pod.Spec.Containers[0].Env, "BUILD_LOCK_TOKEN"); ok {
+		t.Errorf("expected no BUILD_LOCK_TOKEN, got %q", env.Value)
+	}
+}
+
+func TestCustomCreateBuildPodServiceAccountOverride(t *testing.T) {
+	strategy := CustomBuildStrategy{
+		AllowedServiceAccountOverrides: map[string][]string{"builds": {"release-builder"}},
+	}
+
+	tests := []struct {
+		name           string
+		serviceAccount string
+		expectErr      bool
+	}{
+		{
+			name:           "allowed override",
+			serviceAccount: "release-builder",
+		},
+		{
+			name:           "rejected override",
+			serviceAccount: "cluster-admin",
+			expectErr:      true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			build := mockCustomBuild(false, false)
+			build.Namespace = "builds"
+			build.Spec.ServiceAccount = "default"
+			build.Annotations = map[string]string{serviceAccountAnnotation: tc.serviceAccount}
+			pod, err := strategy.CreateBuildPod(build, nil, testInternalRegistryHost)
+			if tc.expectErr {
+				if !IsFatal(err) {
+					t.Errorf("expected fatal error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if pod.Spec.ServiceAccountName != tc.serviceAccount {
+				t.Errorf("expected service account %s, got %s", tc.serviceAccount, pod.Spec.ServiceAccountName)
+			}
+		})
+	}
+}
+
+func TestCustomCreateBuildPodDownwardAPIVolume(t *testing.T) {
+	strategy := CustomBuildStrategy{DownwardAPIMountPath: "/etc/build-metadata"}
+	pod, err := strategy.CreateBuildPod(mockCustomBuild(false, false), nil, testInternalRegistryHost)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var mount *corev1.VolumeMount
+	for i, m := range pod.Spec.Containers[0].VolumeMounts {
+		if m.MountPath == strategy.DownwardAPIMountPath {
+			mount = &pod.Spec.Containers[0].VolumeMounts[i]
+		}
+	}
+	if mount == nil {
+		t.Fatalf("expected the build metadata to be mounted at %s", strategy.DownwardAPIMountPath)
+	}
+	var source *corev1.DownwardAPIVolumeSource
+	for _, v := range pod.Spec.Volumes {
+		if v.Name == mount.Name {
+			source = v.DownwardAPI
+		}
+	}
+	if source == nil {
+		t.Fatalf("expected volume %s to be a downward API volume", mount.Name)
+	}
+	items := map[string]string{}
+	for _, item := range source.Items {
+		if item.FieldRef != nil {
+			items[item.Path] = item.FieldRef.FieldPath
+		}
+	}
+	expected := map[string]string{"labels": "metadata.labels", "annotations": "metadata.annotations"}
+	if !reflect.DeepEqual(expected, items) {
+		t.Errorf("expected downward API items %v, got %v", expected, items)
+	}
+
+	strategy.DownwardAPIMountPath = "build-metadata"
+	if _, err := strategy.CreateBuildPod(mockCustomBuild(false, false), nil, testInternalRegistryHost); !IsFatal(err) {
+		t.Errorf("expected fatal error for a relative mount path, got %v", err)
+	}
+}
+
+type fakeImageMetadataResolver struct {
+	architectures map[string][]string
+}
+
+func (r *fakeImageMetadataResolver) ResolveImageArchitectures(namespace, image string) ([]string, error) {
+	return r.architectures[image], nil
+}
+
+func TestCustomCreateBuildPodImageArchitecture(t *testing.T) {
+	strategy := CustomBuildStrategy{
+		ImageMetadataResolver: &fakeImageMetadataResolver{
+			architectures: map[string][]string{"builder-image": {"amd64", "s390x"}},
+		},
+	}
+
+	tests := []struct {
+		name         string
+		arch         string
+		overrideArch string
+		expectErr    bool
+	}{
+		{
+			name: "matching platform",
+			arch: "amd64",
+		},
+		{
+			name:         "mismatching platform from build overrides",
+			overrideArch: "arm64",
+			expectErr:    true,
+		},
+		{
+			name:      "mismatching platform",
+			arch:      "arm64",
+			expectErr: true,
+		},
+		{
+			name: "no architecture selected",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			build := mockCustomBuild(false, false)
+			build.Spec.NodeSelector = buildv1.OptionalNodeSelector{}
+			if len(tc.arch) > 0 {
+				build.Spec.NodeSelector[corev1.LabelArchStable] = tc.arch
+			}
+			pod, err := strategy.CreateBuildPod(build, nil, testInternalRegistryHost)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			// simulate the node selector merged by build overrides before the pod is finalized
+			if len(tc.overrideArch) > 0 {
+				pod.Spec.NodeSelector[corev1.LabelArchStable] = tc.overrideArch
+			}
+			err = strategy.FinalizeBuildPod(build, pod)
+			if tc.expectErr != IsFatal(err) {
+				t.Errorf("expected fatal error=%v, got %v", tc.expectErr, err)
+			}
+		})
+	}
+}
+
+func TestCustomCreateBuildPodBuildCacheTTL(t *testing.T) {
+	tests := []struct {
+		name     string
+		cacheURL string
+		expected string
+	}{
+		{
+			name:     "caching configured",
+			cacheURL: "https://cache.example.com/builds",
+			expected: "168h0m0s",
+		},
+		{
+			name: "caching not configured",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			strategy := CustomBuildStrategy{BuildCacheURL: tc.cacheURL, BuildCacheTTL: 7 * 24 * time.Hour}
+			pod, err := strategy.CreateBuildPod(mockCustomBuild(false, false), nil, testInternalRegistryHost)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			actual, ok := pod.Annotations[BuildCacheTTLAnnotation]
+			if len(tc.expected) == 0 {
+				if ok {
+					t.Errorf("expected no cache TTL annotation, got %q", actual)
+				}
+				return
+			}
+			if actual != tc.expected {
+				t.Errorf("expected cache TTL annotation %q, got %q", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestCustomCreateBuildPodStorageOptions(t *testing.T) {
+	tests := []struct {
+		name      string
+		options   string
+		expected  string
+		expectErr bool
+	}{
+		{
+			name:     "valid options",
+			options:  "overlay.mountopt=nodev,overlay.mount_program=/usr/bin/fuse-overlayfs",
+			expected: "overlay.mountopt=nodev,overlay.mount_program=/usr/bin/fuse-overlayfs",
+		},
+		{
+			name: "no options",
+		},
+		{
+			name:      "missing value separator",
+			options:   "overlay.mountopt=nodev,metacopy",
+			expectErr: true,
+		},
+		{
+			name:      "empty option",
+			options:   "overlay.mountopt=nodev,,",
+			expectErr: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			strategy := CustomBuildStrategy{StorageOptions: tc.options}
+			pod, err := strategy.CreateBuildPod(mockCustomBuild(false, false), nil, testInternalRegistryHost)
+			if tc.expectErr {
+				if !IsFatal(err) {
+					t.Fatalf("expected fatal error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			env, ok := findEnvVar(pod.Spec.Containers[0].Env, "STORAGE_OPTS")
+			if len(tc.expected) == 0 {
+				if ok {
+					t.Errorf("expected no STORAGE_OPTS, got %q", env.Value)
+				}
+				return
+			}
+			if env.Value != tc.expected {
+				t.Errorf("expected STORAGE_OPTS %q, got %q", tc.expected, env.Value)
+			}
+		})
+	}
+}
+
+func TestCustomCreateBuildPodReferencedSecrets(t *testing.T) {
+	tests := []struct {
+		name      string
+		secrets   []string
+		expectErr string
+	}{
+		{
+			name:    "all secrets in namespace",
+			secrets: []string{"secretFoo", "secret", "foo"},
+		},
+		{
+			name:      "push secret missing",
+			secrets:   []string{"secretFoo", "secret"},
+			expectErr: `secret "foo" referenced by the build does not exist in namespace builds`,
+		},
+		{
+			name:      "secret in another namespace",
+			secrets:   []string{"secretFoo", "foo"},
+			expectErr: `secret "secret" referenced by the build does not exist in namespace builds`,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			objs := []runtime.Object{&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "other", Name: "secret"}}}
+			for _, name := range tc.secrets {
+				objs = append(objs, &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "builds", Name: name}})
+			}
+			strategy := CustomBuildStrategy{
+				SecretLister:              v1lister.NewSecretLister(newIndexer(objs...)),
+				ValidateReferencedSecrets: true,
+			}
+			build := mockCustomBuild(false, false)
+			build.Namespace = "builds"
+			_, err := strategy.CreateBuildPod(build, nil, testInternalRegistryHost)
+			if len(tc.expectErr) == 0 {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if !IsFatal(err) || !strings.Contains(err.Error(), tc.expectErr) {
+				t.Errorf("expected fatal error %q, got %v", tc.expectErr, err)
+			}
+		})
+	}
+
+	strategy := CustomBuildStrategy{ValidateReferencedSecrets: true}
+	if _, err := strategy.CreateBuildPod(mockCustomBuild(false, false), nil, testInternalRegistryHost); err == nil || IsFatal(err) {
+		t.Errorf("expected a non-fatal error without a secret lister, got %v", err)
+	}
+	strategy = CustomBuildStrategy{SecretLister: v1lister.NewSecretLister(newIndexer())}
+	if _, err := strategy.CreateBuildPod(mockCustomBuild(false, false), nil, testInternalRegistryHost); err != nil {
+		t.Errorf("expected referenced secrets not to be validated unless enabled, got %v", err)
+	}
+}
+
+func TestCustomCreateBuildPodGeneratedCorrelationID(t *testing.T) {
+	strategy := CustomBuildStrategy{
+		CorrelationAnnotation: "pipelines.example.com/run-id",
+		GenerateCorrelationID: true,
+	}
+	generated := fmt.Sprintf("%x", sha256.Sum256([]byte("build-uid")))[:32]
+
+	tests := []struct {
+		name          string
+		annotations   map[string]string
+		expectedEnv   string
+		expectedLabel string
+	}{
+		{
+			name:          "generated from build UID",
+			expectedEnv:   generated,
+			expectedLabel: generated,
+		},
+		{
+			name:          "correlation annotation takes precedence",
+			annotations:   map[string]string{"pipelines.example.com/run-id": "argo/run:1234"},
+			expectedEnv:   "argo/run:1234",
+			expectedLabel: "argo-run-1234",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// create two pods to verify the generated ID is stable for a build
+			for i := 0; i < 2; i++ {
+				build := mockCustomBuild(false, false)
+				build.UID = "build-uid"
+				build.Annotations = tc.annotations
+				pod, err := strategy.CreateBuildPod(build, nil, testInternalRegistryHost)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				env, _ := findEnvVar(pod.Spec.Containers[0].Env, "BUILD_CORRELATION_ID")
+				if env.Value != tc.expectedEnv {
+					t.Errorf("expected BUILD_CORRELATION_ID %q, got %q", tc.expectedEnv, env.Value)
+				}
+				if label := pod.Labels[defaultCorrelationLabel]; label != tc.expectedLabel {
+					t.Errorf("expected correlation label %q, got %q", tc.expectedLabel, label)
+				}
+			}
+		})
+	}
+}
+
+func TestCustomCreateBuildPodRestrictMountPaths(t *testing.T) {
+	tests := []struct {
+		name      string
+		mountPath string
+		expectErr bool
+	}{
+		{
+			name:      "conventional build path",
+			mountPath: "/var/run/secrets/openshift.io/custom",
+		},
+		{
+			name:      "mount over /etc",
+			mountPath: "/etc",
+			expectErr: true,
+		},
+		{
+			name:      "mount within /etc",
+			mountPath: "/etc/pki/tls",
+			expectErr: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			strategy := CustomBuildStrategy{RestrictMountPaths: true}
+			build := mockCustomBuild(false, false)
+			build.Spec.Strategy.CustomStrategy.Secrets = []buildv1.SecretSpec{
+				{SecretSource: corev1.LocalObjectReference{Name: "secret"}, MountPath: tc.mountPath},
+			}
+			_, err := strategy.CreateBuildPod(build, nil, testInternalRegistryHost)
+			if tc.expectErr {
+				if !IsFatal(err) {
+					t.Errorf("expected fatal error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestCustomCreateBuildPodRestrictMountPathsConfiguredPaths(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy CustomBuildStrategy
+		build    func(*buildv1.Build)
+	}{
+		{
+			name:     "overridden docker socket path",
+			strategy: CustomBuildStrategy{AllowDockerSocketPathOverride: true},
+			build: func(build *buildv1.Build) {
+				build.Annotations = map[string]string{dockerSocketPathAnnotation: "/run/podman/podman.sock"}
+			},
+		},
+		{
+			name: "git config in a non-root home",
+			strategy: CustomBuildStrategy{
+				GitConfig: &corev1.ConfigMapKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "git"},
+					Key:                  "gitconfig",
+				},
+			},
+			build: func(build *buildv1.Build) {
+				build.Spec.Strategy.CustomStrategy.Env = append(build.Spec.Strategy.CustomStrategy.Env, corev1.EnvVar{Name: "HOME", Value: "/home/builder"})
+			},
+		},
+		{
+			name:     "build metadata directory",
+			strategy: CustomBuildStrategy{DownwardAPIMountPath: "/etc/build-metadata"},
+		},
+		{
+			name:     "secrets directory",
+			strategy: CustomBuildStrategy{SecretsDir: "/run/build-secrets"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			strategy := tc.strategy
+			strategy.RestrictMountPaths = true
+			build := mockCustomBuild(false, false)
+			build.Spec.Strategy.CustomStrategy.Secrets = nil
+			if tc.build != nil {
+				tc.build(build)
+			}
+			if _, err := strategy.CreateBuildPod(build, nil, testInternalRegistryHost); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestCustomCreateBuildPodBuildConfigGeneration(t *testing.T) {
+	strategy := CustomBuildStrategy{InjectBuildConfigGeneration: true}
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		expected    string
+	}{
+		{
+			name:        "valid generation",
+			annotations: map[string]string{buildConfigGenerationAnnotation: "7"},
+			expected:    "7",
+		},
+		{
+			name: "missing annotation",
+		},
+		{
+			name:        "non-numeric value",
+			annotations: map[string]string{buildConfigGenerationAnnotation: "seven"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			build := mockCustomBuild(false, false)
+			build.Annotations = tc.annotations
+			pod, err := strategy.CreateBuildPod(build, nil, testInternalRegistryHost)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			env, ok := findEnvVar(pod.Spec.Containers[0].Env, "BUILD_CONFIG_GENERATION")
+			if len(tc.expected) == 0 {
+				if ok {
+					t.Errorf("expected BUILD_CONFIG_GENERATION to be omitted, got %q", env.Value)
+				}
+				return
+			}
+			if env.Value != tc.expected {
+				t.Errorf("expected BUILD_CONFIG_GENERATION %q, got %q", tc.expected, env.Value)
+			}
+		})
+	}
+}
+
+func TestCustomCreateBuildPodAggregateImagePullSecrets(t *testing.T) {
+	indexer := newIndexer(&corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "builds", Name: buildutil.BuilderServiceAccountName},
+		ImagePullSecrets: []corev1.LocalObjectReference{
+			{Name: "builder-dockercfg"},
+			{Name: "shared"},
+		},
+	})
+	strategy := CustomBuildStrategy{
+		AggregateImagePullSecrets: true,
+		AllowPullSecretAnnotation: true,
+		ServiceAccountLister:      v1lister.NewServiceAccountLister(indexer),
+		DefaultImagePullSecrets: []corev1.LocalObjectReference{
+			{Name: "shared"},
+			{Name: "default"},
+		},
+		GlobalPullSecret: &corev1.LocalObjectReference{Name: "global"},
+	}
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		disallow    bool
+		expected    []string
+	}{
+		{
+			name:        "all sources",
+			annotations: map[string]string{pullSecretAnnotation: "annotated"},
+			expected:    []string{"annotated", "builder-dockercfg", "shared", "default", "global"},
+		},
+		{
+			name:        "annotation naming a service account secret",
+			annotations: map[string]string{pullSecretAnnotation: "shared"},
+			expected:    []string{"shared", "builder-dockercfg", "default", "global"},
+		},
+		{
+			name:     "no annotation",
+			expected: []string{"builder-dockercfg", "shared", "default", "global"},
+		},
+		{
+			name:        "annotation not allowed",
+			annotations: map[string]string{pullSecretAnnotation: "annotated"},
+			disallow:    true,
+			expected:    []string{"builder-dockercfg", "shared", "default", "global"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			strategy := strategy
+			strategy.AllowPullSecretAnnotation = !tc.disallow
+			build := mockCustomBuild(false, false)
+			build.Namespace = "builds"
+			build.Annotations = tc.annotations
+			pod, err := strategy.CreateBuildPod(build, nil, testInternalRegistryHost)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var actual []string
+			for _, s := range pod.Spec.ImagePullSecrets {
+				actual = append(actual, s.Name)
+			}
+			if !reflect.DeepEqual(tc.expected, actual) {
+				t.Errorf("expected image pull secrets %v, got %v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestCustomCreateBuildPodStrictEncoding(t *testing.T) {
+	strategy := CustomBuildStrategy{StrictEncoding: true}
+
+	for _, version := range []string{"", "v1", "build.openshift.io/v1"} {
+		build := mockCustomBuild(false, false)
+		build.Spec.Strategy.CustomStrategy.BuildAPIVersion = version
+		if _, err := strategy.CreateBuildPod(build, nil, testInternalRegistryHost); err != nil {
+			t.Errorf("unexpected error for buildAPIVersion %q: %v", version, err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		field string
+		value interface{}
+	}{
+		{
+			name:  "unexpected field",
+			field: "unexpected",
+			value: "field",
+		},
+		{
+			name:  "field lost in the round trip",
+			field: "serviceAccount",
+			value: "",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := runtime.Encode(customBuildEncodingCodecFactory.LegacyCodec(buildv1.GroupVersion), mockCustomBuild(false, false))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err := validateStrictBuildEncoding(data, buildv1.GroupVersion); err != nil {
+				t.Fatalf("unexpected error for the encoded build: %v", err)
+			}
+			var raw map[string]interface{}
+			if err := json.Unmarshal(data, &raw); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			raw["spec"].(map[string]interface{})[tc.field] = tc.value
+			data, err = json.Marshal(raw)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err := validateStrictBuildEncoding(data, buildv1.GroupVersion); !IsFatal(err) {
+				t.Errorf("expected fatal error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestCustomCreateBuildPodLossyEnvWarnings(t *testing.T) {
+	strategy := CustomBuildStrategy{WarnLossyEnvConversion: true}
+
+	tests := []struct {
+		name     string
+		env      corev1.EnvVar
+		expected string
+	}{
+		{
+			name: "supported valueFrom",
+			env: corev1.EnvVar{
+				Name: "TOKEN",
+				ValueFrom: &corev1.EnvVarSource{
+					SecretKeyRef: &corev1.SecretKeySelector{
+						LocalObjectReference: corev1.LocalObjectReference{Name: "tokens"},
+						Key:                  "token",
+					},
+				},
+			},
+		},
+		{
+			name:     "unsupported valueFrom",
+			env:      corev1.EnvVar{Name: "CERT", ValueFrom: &corev1.EnvVarSource{}},
+			expected: "env CERT uses an unsupported valueFrom source",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			build := mockCustomBuild(false, false)
+			build.Spec.Strategy.CustomStrategy.Env = append(build.Spec.Strategy.CustomStrategy.Env, tc.env)
+			pod, err := strategy.CreateBuildPod(build, nil, testInternalRegistryHost)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			actual, ok := pod.Annotations[EnvWarningsAnnotation]
+			if len(tc.expected) == 0 {
+				if ok {
+					t.Errorf("expected no env warnings, got %q", actual)
+				}
+				return
+			}
+			if actual != tc.expected {
+				t.Errorf("expected env warnings %q, got %q", tc.expected, actual)
+			}
+		})
+	}
+}
+
+// newIndexer returns an indexer with a namespace index holding the given objects,
+// for the listers of the strategy.
+func newIndexer(objs ...runtime.Object) cache.Indexer {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for _, obj := range objs {
+		indexer.Add(obj)
+	}
+	return indexer
+}
@@ -1,18 +1,30 @@
 package strategy
 
 import (
+	"crypto/sha256"
+	"encoding/json"
 	"fmt"
+	"math"
+	"net/url"
 	"path/filepath"
 	"reflect"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/uuid"
 	kvalidation "k8s.io/apimachinery/pkg/util/validation"
+	v1lister "k8s.io/client-go/listers/core/v1"
 	"k8s.io/klog/v2"
 	"k8s.io/kubernetes/pkg/apis/policy"
+	"k8s.io/utils/clock"
 
 	buildv1 "github.com/openshift/api/build/v1"
 	"github.com/openshift/library-go/pkg/build/naming"
@@ -30,11 +42,22 @@ const (
 	ConfigMapBuildSourceBaseMountPath    = "/var/run/configs/openshift.io/build"
 	ConfigMapBuildSystemConfigsMountPath = "/var/run/configs/openshift.io/build-system"
 	ConfigMapCertsMountPath              = "/var/run/configs/openshift.io/certs"
+	ConfigMapEntrypointMountPath         = "/var/run/configs/openshift.io/entrypoint"
+	ContainersRegistriesConfPath         = "/etc/containers/registries.conf"
 	SecretBuildSourceBaseMountPath       = "/var/run/secrets/openshift.io/build"
 	SourceImagePullSecretMountPath       = "/var/run/secrets/openshift.io/source-image"
+	BuildCacheSecretMountPath            = "/var/run/secrets/openshift.io/build-cache"
+	ServiceAccountTokenMountPath         = "/var/run/secrets/openshift.io/token"
+	GPGKeyringMountPath                  = "/var/run/secrets/openshift.io/gpg"
 	// ConfigMapBuildGlobalCAMountPath is the directory where cluster-wide trust bundle will be
 	// mounted in the build pod
 	ConfigMapBuildGlobalCAMountPath = "/var/run/configs/openshift.io/pki"
+	// NodeCATrustMountPath is the directory where the node's /etc/pki is mounted
+	// in the build pod, when enabled.
+	NodeCATrustMountPath = "/var/run/configs/openshift.io/node-pki"
+	// GPGHomeMountPath is the writable directory used as GNUPGHOME in the build pod,
+	// into which the builder imports the keyring mounted at GPGKeyringMountPath.
+	GPGHomeMountPath = "/var/run/openshift.io/gnupg"
 
 	// ExtractImageContentContainer is the name of the container that will
 	// pull down input images and extract their content for input to the build.
@@ -44,10 +67,104 @@ const (
 	// build source repository and also handle binary input content.
 	GitCloneContainer = "git-clone"
 
+	// DeadlineWatcherContainer is the name of the container that will signal
+	// the builder to shut down before the build deadline is reached.
+	DeadlineWatcherContainer = "deadline-watcher"
+
+	// defaultRegistryAuthFileEnvVar is read by buildah and podman to locate
+	// registry credentials.
+	defaultRegistryAuthFileEnvVar = "REGISTRY_AUTH_FILE"
+
+	// featureFlagEnvVarPrefix is the prefix of the environment variables holding
+	// builder feature flags.
+	featureFlagEnvVarPrefix = "BUILD_FEATURE_"
+
+	// defaultBuilderHome is the home directory of builders which do not set HOME,
+	// matching the root user privileged builds run as.
+	defaultBuilderHome = "/root"
+
 	// buildVolumeMountPath is where user defined BuildVolumes get mounted
 	buildVolumeMountPath = "/var/run/openshift.io/volumes"
 	// buildVolumeSuffix is a suffix for BuildVolume names
 	buildVolumeSuffix = "user-build-volume"
+
+	// criticalBuildAnnotation marks a build which should not be disrupted by
+	// cluster maintenance.
+	criticalBuildAnnotation = "openshift.io/build.critical"
+	// tokenAudienceAnnotation overrides the audience of the service account
+	// token projected into the builder, when the strategy allows it.
+	tokenAudienceAnnotation = "openshift.io/build.token-audience"
+	// detachedPodAnnotation requests that the build pod is not owned by the
+	// build, for debugging garbage collection.
+	detachedPodAnnotation = "openshift.io/build.detached-pod"
+	// DetachedBuildUIDAnnotation holds the UID of the build a detached build pod was
+	// created for, as the pod has no owner reference to it.
+	DetachedBuildUIDAnnotation = "openshift.io/build.detached-build-uid"
+	// BuildAttemptAnnotation records how many times a pod was created for the
+	// build, so the attempts can be tracked across pod recreations.
+	BuildAttemptAnnotation = "openshift.io/build.attempt"
+	// BuilderImageDigestAnnotation records the digest the builder image resolved
+	// to when the build pod was created.
+	BuilderImageDigestAnnotation = "openshift.io/build.builder-image-digest"
+	// QOSClassAnnotation records the QoS class the build pod is expected to get.
+	QOSClassAnnotation = "openshift.io/build.qos-class"
+	// SecurityPostureAnnotation summarizes the security settings of the build pod.
+	SecurityPostureAnnotation = "openshift.io/build.security-posture"
+	// BuildChecksumAnnotation holds the SHA-256 checksum of the encoded build
+	// passed to the builder.
+	BuildChecksumAnnotation = "openshift.io/build.checksum"
+	// dockerSocketPathAnnotation overrides the path of the docker socket
+	// exposed to the build.
+	dockerSocketPathAnnotation = "openshift.io/build.docker-socket-path"
+	// CommitDateAnnotation holds the RFC3339 committer date of the commit in the
+	// source revision of the build, which the revision itself does not carry.
+	// Neither the build controller nor the API server set it: it is a contract
+	// for the clients which create builds for a known commit, such as CI systems
+	// creating Build objects directly, to pass the date of the commit.
+	CommitDateAnnotation = "openshift.io/build.commit.date"
+	// pullSecretAnnotation names the pull secret of the build.
+	pullSecretAnnotation = "openshift.io/build.pull-secret"
+	// EnvNamesAnnotation lists the names of the environment variables of the
+	// builder.
+	EnvNamesAnnotation = "openshift.io/build.env-names"
+	// EnvWarningsAnnotation lists the environment variables of the build which
+	// could not be fully passed to the builder.
+	EnvWarningsAnnotation = "openshift.io/build.env-warnings"
+	// serviceAccountAnnotation overrides the service account of the build.
+	serviceAccountAnnotation = "openshift.io/build.service-account"
+	// BuildCacheTTLAnnotation holds the duration after which the unused remote
+	// build cache of the build may be pruned.
+	BuildCacheTTLAnnotation = "openshift.io/build.cache-ttl"
+	// EffectiveNodeSelectorAnnotation records the node selector of the build pod
+	// after all sources of node selectors are merged.
+	EffectiveNodeSelectorAnnotation = "openshift.io/build.effective-node-selector"
+	// buildConfigGenerationAnnotation holds the generation of the BuildConfig
+	// the build was created from.
+	buildConfigGenerationAnnotation = "openshift.io/build-config.generation"
+	// imageSizeAnnotation holds the estimated size of the output image, as a
+	// resource quantity.
+	imageSizeAnnotation = "openshift.io/build.image-size"
+
+	// defaultMaxImageSources is the default maximum number of input images of a
+	// build.
+	defaultMaxImageSources = 100
+
+	// defaultPushConcurrency matches the default number of concurrent uploads of
+	// the docker daemon.
+	defaultPushConcurrency = 5
+
+	// defaultQuotaLabelKey and defaultQuotaLabelValue make up the label set on
+	// build pods for ResourceQuota scope selectors.
+	defaultQuotaLabelKey   = "workload-type"
+	defaultQuotaLabelValue = "build"
+
+	// defaultCorrelationLabel is the pod label holding the correlation ID of the
+	// build when no other label is configured.
+	defaultCorrelationLabel = "openshift.io/build.correlation-id"
+
+	// defaultTerminationGracePeriodSeconds matches the pod default and is used
+	// as the base for size based termination grace periods.
+	defaultTerminationGracePeriodSeconds = int64(30)
 )
 
 const (
@@ -62,6 +179,9 @@ var (
 	BuildControllerRefKind = buildv1.GroupVersion.WithKind("Build")
 )
 
+// invalidLabelValueRegex matches characters which are not allowed in label values
+var invalidLabelValueRegex = regexp.MustCompile("[^A-Za-z0-9._-]+")
+
 // hostPortRegex matches the final "..[port]" in ConfigMap keys
 var hostPortRegex = regexp.MustCompile("\\.\\.(\\d+)$")
 
@@ -82,20 +202,24 @@ func IsFatal(err error) bool {
 	return isFatal
 }
 
-// setupDockerSocket configures the pod to support the host's Docker socket
-func setupDockerSocket(pod *corev1.Pod) {
+// setupDockerSocket configures the pod to support the host's Docker socket, which is
+// mounted at the same path in the pod. The host path must be a socket, so a
+// mistyped path fails the pod instead of creating an empty directory on the node.
+func setupDockerSocket(pod *corev1.Pod, socketPath string) {
+	hostPathSocket := corev1.HostPathSocket
 	dockerSocketVolume := corev1.Volume{
 		Name: "docker-socket",
 		VolumeSource: corev1.VolumeSource{
 			HostPath: &corev1.HostPathVolumeSource{
-				Path: dockerSocketPath,
+				Path: socketPath,
+				Type: &hostPathSocket,
 			},
 		},
 	}
 
 	dockerSocketVolumeMount := corev1.VolumeMount{
 		Name:      "docker-socket",
-		MountPath: dockerSocketPath,
+		MountPath: socketPath,
 	}
 
 	pod.Spec.Volumes = append(pod.Spec.Volumes,
@@ -111,6 +235,19 @@ func setupDockerSocket(pod *corev1.Pod) {
 	}
 }
 
+// dockerSocketPathForBuild returns the docker socket path requested by the build
+// annotation, or the default path. The requested path must be absolute.
+func dockerSocketPathForBuild(build *buildv1.Build) (string, error) {
+	socketPath, ok := build.Annotations[dockerSocketPathAnnotation]
+	if !ok {
+		return dockerSocketPath, nil
+	}
+	if !filepath.IsAbs(socketPath) {
+		return "", &FatalError{fmt.Sprintf("docker socket path %q of build %s/%s must be absolute", socketPath, build.Namespace, build.Name)}
+	}
+	return filepath.Clean(socketPath), nil
+}
+
 // mountConfigMapVolume is a helper method responsible for actual mounting configMap
 // volumes into a pod.
 func mountConfigMapVolume(pod *corev1.Pod, container *corev1.Container, configMapName, mountPath, volumeSuffix string, volumeSource *corev1.VolumeSource) {
@@ -141,222 +278,1706 @@ func mountVolume(pod *corev1.Pod, container *corev1.Container, objName, mountPat
 	// coerce from RFC1123 subdomain to RFC1123 label.
 	volumeName = strings.Replace(volumeName, ".", "-", -1)
 
-	volumeExists := false
-	for _, v := range pod.Spec.Volumes {
-		if v.Name == volumeName {
-			volumeExists = true
-			break
-		}
+	volumeExists := false
+	for _, v := range pod.Spec.Volumes {
+		if v.Name == volumeName {
+			volumeExists = true
+			break
+		}
+	}
+	mode := int32(0o600)
+	if container.SecurityContext == nil || container.SecurityContext.Privileged == nil || !*container.SecurityContext.Privileged {
+		mode = int32(0o644) // make sure unprivileged builders can read them
+	}
+	if !volumeExists {
+		volume := makeVolume(volumeName, objName, mode, fsType, volumeSource)
+		pod.Spec.Volumes = append(pod.Spec.Volumes, volume)
+	}
+
+	volumeMount := corev1.VolumeMount{
+		Name:      volumeName,
+		MountPath: mountPath,
+		ReadOnly:  true,
+	}
+	container.VolumeMounts = append(container.VolumeMounts, volumeMount)
+}
+
+func makeVolume(volumeName, refName string, mode int32, fsType policy.FSType, volumeSource *corev1.VolumeSource) corev1.Volume {
+	// TODO: Add support for key-based paths for secrets and configMaps?
+	vol := corev1.Volume{
+		Name:         volumeName,
+		VolumeSource: corev1.VolumeSource{},
+	}
+	switch fsType {
+	case policy.ConfigMap:
+		if volumeSource != nil && volumeSource.ConfigMap != nil {
+			vol.VolumeSource.ConfigMap = volumeSource.ConfigMap.DeepCopy()
+		} else {
+			vol.VolumeSource.ConfigMap = &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{
+					Name: refName,
+				},
+				DefaultMode: &mode,
+			}
+		}
+	case policy.EmptyDir:
+		if volumeSource != nil && volumeSource.EmptyDir != nil {
+			vol.VolumeSource.EmptyDir = volumeSource.EmptyDir.DeepCopy()
+		} else {
+			vol.VolumeSource.EmptyDir = &corev1.EmptyDirVolumeSource{}
+		}
+	case policy.Secret:
+		if volumeSource != nil && volumeSource.Secret != nil {
+			vol.VolumeSource.Secret = volumeSource.Secret.DeepCopy()
+		} else {
+			vol.VolumeSource.Secret = &corev1.SecretVolumeSource{
+				SecretName:  refName,
+				DefaultMode: &mode,
+			}
+		}
+	case policy.CSI:
+		if volumeSource != nil && volumeSource.CSI != nil {
+			vol.VolumeSource.CSI = volumeSource.CSI.DeepCopy()
+		} else {
+			vol.VolumeSource.CSI = &corev1.CSIVolumeSource{}
+		}
+	default:
+		klog.V(3).Infof("File system %s is not supported for volumes. Using empty directory instead.", fsType)
+		vol.VolumeSource.EmptyDir = &corev1.EmptyDirVolumeSource{}
+	}
+
+	return vol
+}
+
+// setupDockerSecrets mounts Docker Registry secrets into Pod running the build,
+// allowing Docker to authenticate against private registries or Docker Hub.
+func setupDockerSecrets(pod *corev1.Pod, container *corev1.Container, pushSecret, pullSecret *corev1.LocalObjectReference, imageSources []buildv1.ImageSource) {
+	if pushSecret != nil {
+		mountSecretVolume(pod, container, pushSecret.Name, DockerPushSecretMountPath, "push", nil)
+		container.Env = append(container.Env, []corev1.EnvVar{
+			{Name: "PUSH_DOCKERCFG_PATH", Value: DockerPushSecretMountPath},
+		}...)
+		klog.V(3).Infof("%s will be used for docker push in %s", DockerPushSecretMountPath, pod.Name)
+	}
+
+	if pullSecret != nil {
+		mountSecretVolume(pod, container, pullSecret.Name, DockerPullSecretMountPath, "pull", nil)
+		container.Env = append(container.Env, []corev1.EnvVar{
+			{Name: "PULL_DOCKERCFG_PATH", Value: DockerPullSecretMountPath},
+		}...)
+		klog.V(3).Infof("%s will be used for docker pull in %s", DockerPullSecretMountPath, pod.Name)
+	}
+
+	for i, imageSource := range imageSources {
+		if imageSource.PullSecret == nil {
+			continue
+		}
+		mountPath := filepath.Join(SourceImagePullSecretMountPath, strconv.Itoa(i))
+		mountSecretVolume(pod, container, imageSource.PullSecret.Name, mountPath, fmt.Sprintf("%s%d", "source-image", i), nil)
+		container.Env = append(container.Env, []corev1.EnvVar{
+			{Name: fmt.Sprintf("%s%d", "PULL_SOURCE_DOCKERCFG_PATH_", i), Value: mountPath},
+		}...)
+		klog.V(3).Infof("%s will be used for docker pull in %s", mountPath, pod.Name)
+	}
+}
+
+// pullSecretForBuild returns the pull secret named by the build annotation, or the
+// given pull secret if the build has no such annotation.
+func pullSecretForBuild(build *buildv1.Build, pullSecret *corev1.LocalObjectReference) (*corev1.LocalObjectReference, error) {
+	name, ok := build.Annotations[pullSecretAnnotation]
+	if !ok {
+		return pullSecret, nil
+	}
+	if errs := kvalidation.IsDNS1123Subdomain(name); len(errs) > 0 {
+		return nil, &FatalError{fmt.Sprintf("invalid pull secret name %q: %s", name, strings.Join(errs, ", "))}
+	}
+	return &corev1.LocalObjectReference{Name: name}, nil
+}
+
+// setupImagePullSecrets sets the image pull secrets of the pod to the pull secret named
+// by the build annotation, if allowed, followed by the image pull secrets of the service
+// account of the pod, the given default secrets and the global pull secret. Secrets are
+// listed once, at the position of their first source.
+func setupImagePullSecrets(pod *corev1.Pod, build *buildv1.Build, allowAnnotation bool, lister v1lister.ServiceAccountLister, defaults []corev1.LocalObjectReference, global *corev1.LocalObjectReference) error {
+	var sources [][]corev1.LocalObjectReference
+	if allowAnnotation {
+		annotated, err := pullSecretForBuild(build, nil)
+		if err != nil {
+			return err
+		}
+		if annotated != nil {
+			sources = append(sources, []corev1.LocalObjectReference{*annotated})
+		}
+	}
+	if lister != nil {
+		sa, err := lister.ServiceAccounts(pod.Namespace).Get(pod.Spec.ServiceAccountName)
+		switch {
+		case kerrors.IsNotFound(err):
+			klog.V(4).Infof("Service account %s/%s not found, ignoring its image pull secrets", pod.Namespace, pod.Spec.ServiceAccountName)
+		case err != nil:
+			return fmt.Errorf("failed to get service account %s/%s: %v", pod.Namespace, pod.Spec.ServiceAccountName, err)
+		default:
+			sources = append(sources, sa.ImagePullSecrets)
+		}
+	}
+	sources = append(sources, defaults)
+	if global != nil {
+		sources = append(sources, []corev1.LocalObjectReference{*global})
+	}
+
+	var secrets []corev1.LocalObjectReference
+	seen := sets.NewString()
+	for _, source := range sources {
+		for _, s := range source {
+			if len(s.Name) == 0 || seen.Has(s.Name) {
+				continue
+			}
+			seen.Insert(s.Name)
+			secrets = append(secrets, s)
+		}
+	}
+	pod.Spec.ImagePullSecrets = secrets
+	return nil
+}
+
+// serviceAccountForBuild returns the service account named by the build annotation,
+// or the given service account if the build has no such annotation. The named
+// service account must be allowed for the namespace of the build.
+func serviceAccountForBuild(build *buildv1.Build, serviceAccount string, allowed map[string][]string) (string, error) {
+	name, ok := build.Annotations[serviceAccountAnnotation]
+	if !ok {
+		return serviceAccount, nil
+	}
+	if !sets.NewString(allowed[build.Namespace]...).Has(name) {
+		return "", &FatalError{fmt.Sprintf("service account %q is not allowed for builds in namespace %s", name, build.Namespace)}
+	}
+	klog.V(2).Infof("Build %s/%s overrides its service account with %s", build.Namespace, build.Name, name)
+	return name, nil
+}
+
+// addRegistryAuthFileEnvVar points the given environment variable at the docker config
+// of the mounted push secret, or of the pull secret if there is no push secret, so
+// builders not using a docker socket authenticate against registries. Only secrets of
+// type kubernetes.io/dockerconfigjson have the file the variable points at, so it is
+// not set for other secrets, nor when the type cannot be looked up without a lister.
+func addRegistryAuthFileEnvVar(container *corev1.Container, lister v1lister.SecretLister, namespace string, pushSecret, pullSecret *corev1.LocalObjectReference, name string) error {
+	if len(name) == 0 {
+		name = defaultRegistryAuthFileEnvVar
+	}
+	var mountPath string
+	var secret *corev1.LocalObjectReference
+	switch {
+	case pushSecret != nil:
+		mountPath, secret = DockerPushSecretMountPath, pushSecret
+	case pullSecret != nil:
+		mountPath, secret = DockerPullSecretMountPath, pullSecret
+	default:
+		return nil
+	}
+	if lister == nil {
+		return nil
+	}
+	s, err := lister.Secrets(namespace).Get(secret.Name)
+	if kerrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get secret %s/%s: %v", namespace, secret.Name, err)
+	}
+	if s.Type != corev1.SecretTypeDockerConfigJson {
+		klog.V(4).Infof("Secret %s/%s is of type %s, not setting %s", namespace, secret.Name, s.Type, name)
+		return nil
+	}
+	container.Env = append(container.Env, corev1.EnvVar{Name: name, Value: filepath.Join(mountPath, corev1.DockerConfigJsonKey)})
+	return nil
+}
+
+// setupSourceSecrets mounts SSH key used for accessing private SCM to clone
+// application source code during build.
+func setupSourceSecrets(pod *corev1.Pod, container *corev1.Container, sourceSecret *corev1.LocalObjectReference) {
+	if sourceSecret == nil {
+		return
+	}
+
+	mountSecretVolume(pod, container, sourceSecret.Name, sourceSecretMountPath, "source", nil)
+	klog.V(3).Infof("Installed source secrets in %s, in Pod %s/%s", sourceSecretMountPath, pod.Namespace, pod.Name)
+	container.Env = append(container.Env, []corev1.EnvVar{
+		{Name: "SOURCE_SECRET_PATH", Value: sourceSecretMountPath},
+	}...)
+}
+
+// setupInputConfigMaps mounts the configMaps referenced by the ConfigMapBuildSource
+// into a builder container.
+func setupInputConfigMaps(pod *corev1.Pod, container *corev1.Container, configs []buildv1.ConfigMapBuildSource) {
+	for _, c := range configs {
+		mountConfigMapVolume(pod, container, c.ConfigMap.Name, filepath.Join(ConfigMapBuildSourceBaseMountPath, c.ConfigMap.Name), "build", nil)
+		klog.V(3).Infof("%s will be used as a build config in %s", c.ConfigMap.Name, ConfigMapBuildSourceBaseMountPath)
+	}
+}
+
+// setupInputSecrets mounts the secrets referenced by the SecretBuildSource
+// into a builder container.
+func setupInputSecrets(pod *corev1.Pod, container *corev1.Container, secrets []buildv1.SecretBuildSource) {
+	for _, s := range secrets {
+		mountSecretVolume(pod, container, s.Secret.Name, filepath.Join(SecretBuildSourceBaseMountPath, s.Secret.Name), "build", nil)
+		klog.V(3).Infof("%s will be used as a build secret in %s", s.Secret.Name, SecretBuildSourceBaseMountPath)
+	}
+}
+
+// setupInputEnvFrom references the secrets and configMaps of the build source from the
+// container envFrom, so all of their keys are exposed as environment variables.
+// Sources which are referenced more than once, or which are also referenced by
+// the container env, are rejected.
+func setupInputEnvFrom(container *corev1.Container, secrets []buildv1.SecretBuildSource, configs []buildv1.ConfigMapBuildSource) error {
+	envSecrets, envConfigMaps := sets.NewString(), sets.NewString()
+	for _, env := range container.Env {
+		if env.ValueFrom == nil {
+			continue
+		}
+		if env.ValueFrom.SecretKeyRef != nil {
+			envSecrets.Insert(env.ValueFrom.SecretKeyRef.Name)
+		}
+		if env.ValueFrom.ConfigMapKeyRef != nil {
+			envConfigMaps.Insert(env.ValueFrom.ConfigMapKeyRef.Name)
+		}
+	}
+
+	seen := sets.NewString()
+	for _, s := range secrets {
+		if seen.Has(s.Secret.Name) || envSecrets.Has(s.Secret.Name) {
+			return &FatalError{fmt.Sprintf("secret %q is referenced more than once in the build environment", s.Secret.Name)}
+		}
+		seen.Insert(s.Secret.Name)
+		container.EnvFrom = append(container.EnvFrom, corev1.EnvFromSource{
+			SecretRef: &corev1.SecretEnvSource{LocalObjectReference: s.Secret},
+		})
+		klog.V(3).Infof("%s will be used as a build secret via envFrom", s.Secret.Name)
+	}
+
+	seen = sets.NewString()
+	for _, c := range configs {
+		if seen.Has(c.ConfigMap.Name) || envConfigMaps.Has(c.ConfigMap.Name) {
+			return &FatalError{fmt.Sprintf("configMap %q is referenced more than once in the build environment", c.ConfigMap.Name)}
+		}
+		seen.Insert(c.ConfigMap.Name)
+		container.EnvFrom = append(container.EnvFrom, corev1.EnvFromSource{
+			ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: c.ConfigMap},
+		})
+		klog.V(3).Infof("%s will be used as a build config via envFrom", c.ConfigMap.Name)
+	}
+	return nil
+}
+
+// addSourceEnvVars adds environment variables related to the source code
+// repository to builder container
+func addSourceEnvVars(source buildv1.BuildSource, output *[]corev1.EnvVar) {
+	sourceVars := []corev1.EnvVar{}
+	if source.Git != nil {
+		sourceVars = append(sourceVars, corev1.EnvVar{Name: "SOURCE_REPOSITORY", Value: source.Git.URI})
+		sourceVars = append(sourceVars, corev1.EnvVar{Name: "SOURCE_URI", Value: source.Git.URI})
+	}
+	if len(source.ContextDir) > 0 {
+		sourceVars = append(sourceVars, corev1.EnvVar{Name: "SOURCE_CONTEXT_DIR", Value: source.ContextDir})
+	}
+	if source.Git != nil && len(source.Git.Ref) > 0 {
+		sourceVars = append(sourceVars, corev1.EnvVar{Name: "SOURCE_REF", Value: source.Git.Ref})
+	}
+	*output = append(*output, sourceVars...)
+}
+
+// addNoSourceEnvVar sets the BUILD_SOURCE_NONE environment variable for builds without
+// git or binary source, so builders can assert they were not expecting any.
+func addNoSourceEnvVar(source buildv1.BuildSource, output *[]corev1.EnvVar) {
+	if source.Git != nil || source.Binary != nil {
+		return
+	}
+	*output = append(*output, corev1.EnvVar{Name: "BUILD_SOURCE_NONE", Value: "true"})
+}
+
+// addOutputEnvVars adds env variables that provide information about the output
+// target for the build
+func addOutputEnvVars(buildOutput *corev1.ObjectReference, output *[]corev1.EnvVar) error {
+	if buildOutput == nil {
+		return nil
+	}
+
+	// output must always be a DockerImage type reference at this point.
+	if buildOutput.Kind != "DockerImage" {
+		return fmt.Errorf("invalid build output kind %s, must be DockerImage", buildOutput.Kind)
+	}
+	ref, err := reference.Parse(buildOutput.Name)
+	if err != nil {
+		return err
+	}
+	registry := ref.Registry
+	ref.Registry = ""
+	image := ref.String()
+
+	outputVars := []corev1.EnvVar{
+		{Name: "OUTPUT_REGISTRY", Value: registry},
+		{Name: "OUTPUT_IMAGE", Value: image},
+	}
+
+	*output = append(*output, outputVars...)
+	return nil
+}
+
+// addTrustedCAMountEnvVar sets the BUILD_MOUNT_ETC_PKI_CATRUST environment variable if the build
+// pod needs the CA trust bundle (`/etc/pki/ca-trust`) mounted into build processes.
+func addTrustedCAMountEnvVar(mountTrustedCA *bool, envVars *[]corev1.EnvVar) {
+	if mountTrustedCA != nil {
+		*envVars = append(*envVars, corev1.EnvVar{Name: "BUILD_MOUNT_ETC_PKI_CATRUST", Value: strconv.FormatBool(*mountTrustedCA)})
+	}
+}
+
+// addCPULimitEnvVar sets the BUILD_CPU_LIMIT environment variable to the cpu limit of
+// the container, rounded up to whole cores, so builders can size their parallelism.
+// The limit is read from the container so limits set by build defaults and overrides
+// are honored. A BUILD_CPU_LIMIT already set in the build environment is kept.
+func addCPULimitEnvVar(container *corev1.Container) {
+	limit, ok := container.Resources.Limits[corev1.ResourceCPU]
+	if !ok || limit.IsZero() {
+		return
+	}
+	for _, env := range container.Env {
+		if env.Name == "BUILD_CPU_LIMIT" {
+			return
+		}
+	}
+	container.Env = append(container.Env, corev1.EnvVar{Name: "BUILD_CPU_LIMIT", Value: strconv.FormatInt(limit.Value(), 10)})
+}
+
+// addNumericAnnotationEnvVar sets the named environment variable to the value of the
+// given build annotation. The variable is omitted when the annotation is missing or
+// is not a number.
+func addNumericAnnotationEnvVar(build *buildv1.Build, annotation, name string, envVars *[]corev1.EnvVar) {
+	value, ok := build.Annotations[annotation]
+	if !ok {
+		return
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		klog.V(4).Infof("Ignoring non-numeric annotation %s=%q of build %s/%s", annotation, value, build.Namespace, build.Name)
+		return
+	}
+	*envVars = append(*envVars, corev1.EnvVar{Name: name, Value: strconv.FormatInt(n, 10)})
+}
+
+// addFeatureFlagEnvVars adds the feature flags to the environment variables, except
+// for the flags which are overridden by the build environment. Flags which do not
+// follow the BUILD_FEATURE_ naming convention are rejected.
+func addFeatureFlagEnvVars(flags, buildEnv []corev1.EnvVar, envVars *[]corev1.EnvVar) error {
+	overridden := sets.NewString()
+	for _, env := range buildEnv {
+		overridden.Insert(env.Name)
+	}
+	for _, flag := range flags {
+		if !strings.HasPrefix(flag.Name, featureFlagEnvVarPrefix) || len(flag.Name) == len(featureFlagEnvVarPrefix) {
+			return &FatalError{fmt.Sprintf("feature flag %q must be named %s<FEATURE>", flag.Name, featureFlagEnvVarPrefix)}
+		}
+		if errs := kvalidation.IsEnvVarName(flag.Name); len(errs) > 0 {
+			return &FatalError{fmt.Sprintf("feature flag %q is not a valid environment variable name: %s", flag.Name, strings.Join(errs, ", "))}
+		}
+		if overridden.Has(flag.Name) {
+			klog.V(4).Infof("Feature flag %s is overridden by the build environment", flag.Name)
+			continue
+		}
+		*envVars = append(*envVars, corev1.EnvVar{Name: flag.Name, Value: flag.Value})
+	}
+	return nil
+}
+
+// addBuildCreatedEnvVar sets the BUILD_CREATED environment variable to the creation
+// time of the build in RFC3339 format, so that builders stamp the same date no
+// matter when the pod runs. The current time of the clock is used for builds
+// without a creation timestamp.
+func addBuildCreatedEnvVar(build *buildv1.Build, c clock.PassiveClock, envVars *[]corev1.EnvVar) {
+	created := build.CreationTimestamp.Time
+	if created.IsZero() {
+		if c == nil {
+			c = clock.RealClock{}
+		}
+		created = c.Now()
+	}
+	*envVars = append(*envVars, corev1.EnvVar{Name: "BUILD_CREATED", Value: created.UTC().Format(time.RFC3339)})
+}
+
+// reservedEnvVars are the environment variables through which the build controller
+// passes the build, its source and output, and the locations of the credentials and
+// configuration it mounts to the builder. Builds may not set the ones the build
+// controller sets in their pod, as the builder would otherwise act on values which
+// do not match the pod. Builds remain free to set the others, such as STORAGE_OPTS
+// for a builder the controller passes no storage options to.
+//
+// The other variables set by the build controller, such as LANG, BUILD_NUMBER,
+// BUILD_CREATED, BUILD_PUSH_CONCURRENCY and the optional build hints, are not
+// reserved. The build environment is appended after them and takes precedence.
+var reservedEnvVars = sets.NewString(
+	"BUILD",
+	"SOURCE_REPOSITORY",
+	"SOURCE_URI",
+	"SOURCE_CONTEXT_DIR",
+	"SOURCE_REF",
+	"OUTPUT_REGISTRY",
+	"OUTPUT_IMAGE",
+	"DOCKER_SOCKET",
+	"PUSH_DOCKERCFG_PATH",
+	"PULL_DOCKERCFG_PATH",
+	"SOURCE_SECRET_PATH",
+	"BUILD_SECRETS_DIR",
+	"BUILD_TOKEN_PATH",
+	defaultRegistryAuthFileEnvVar,
+	"GNUPGHOME",
+	"BUILD_GPG_KEYRING_DIR",
+	"GIT_CONFIG_GLOBAL",
+	"CONTAINERS_REGISTRIES_CONF",
+	"BUILD_REGISTRIES_CONF_PATH",
+	"BUILD_REGISTRIES_DIR_PATH",
+	"BUILD_SIGNATURE_POLICY_PATH",
+	"BUILD_STORAGE_CONF_PATH",
+	"BUILD_CACHE_URL",
+	"BUILD_CACHE_CREDENTIALS_PATH",
+	"BUILD_BLOBCACHE_DIR",
+	"STORAGE_OPTS",
+)
+
+// reservedEnvNames returns the names of reservedEnvVars which the build controller set
+// in the given container environment, besides their definitions in the given build
+// environment. BUILD is always reserved.
+func reservedEnvNames(containerEnv, buildEnv []corev1.EnvVar) sets.String {
+	counts := map[string]int{}
+	for _, env := range containerEnv {
+		counts[env.Name]++
+	}
+	for _, env := range buildEnv {
+		counts[env.Name]--
+	}
+	names := sets.NewString("BUILD")
+	for name, count := range counts {
+		if count > 0 && reservedEnvVars.Has(name) {
+			names.Insert(name)
+		}
+	}
+	return names
+}
+
+// validateReservedEnv returns a FatalError if one of the build environment variables
+// is reserved.
+func validateReservedEnv(buildEnv []corev1.EnvVar, reserved sets.String) error {
+	for _, env := range buildEnv {
+		if reserved.Has(env.Name) {
+			return &FatalError{fmt.Sprintf("environment variable %s is reserved by the build controller and cannot be set", env.Name)}
+		}
+	}
+	return nil
+}
+
+// validateEnvFromConfigMap returns a FatalError if the ConfigMap has a key named after
+// a reserved variable. A missing ConfigMap is not an error, as it is optional.
+func validateEnvFromConfigMap(lister v1lister.ConfigMapLister, namespace, name string, reserved sets.String) error {
+	if lister == nil {
+		return fmt.Errorf("build environment ConfigMap %s/%s cannot be validated without a ConfigMap lister", namespace, name)
+	}
+	cm, err := lister.ConfigMaps(namespace).Get(name)
+	if kerrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get build environment ConfigMap %s/%s: %v", namespace, name, err)
+	}
+	for key := range cm.Data {
+		if reserved.Has(key) {
+			return &FatalError{fmt.Sprintf("key %s of build environment ConfigMap %s/%s is reserved by the build controller", key, namespace, name)}
+		}
+	}
+	return nil
+}
+
+// envValueFromWarnings returns a warning for each environment variable with a valueFrom
+// source of an unknown kind, and for each variable whose valueFrom source differs in
+// the encoded environment.
+func envValueFromWarnings(env, encodedEnv []corev1.EnvVar, version string) []string {
+	var warnings []string
+	for i, e := range env {
+		if e.ValueFrom == nil {
+			continue
+		}
+		if e.ValueFrom.FieldRef == nil && e.ValueFrom.ResourceFieldRef == nil && e.ValueFrom.ConfigMapKeyRef == nil && e.ValueFrom.SecretKeyRef == nil {
+			warnings = append(warnings, fmt.Sprintf("env %s uses an unsupported valueFrom source", e.Name))
+			continue
+		}
+		if i >= len(encodedEnv) || encodedEnv[i].Name != e.Name || !reflect.DeepEqual(e.ValueFrom, encodedEnv[i].ValueFrom) {
+			warnings = append(warnings, fmt.Sprintf("env %s valueFrom cannot be represented in %s", e.Name, version))
+		}
+	}
+	return warnings
+}
+
+// addSourceDateEpochEnvVar sets the SOURCE_DATE_EPOCH environment variable to the
+// committer date of the resolved source commit held in the CommitDateAnnotation, falling
+// back to the creation time of the build. The variable is omitted when neither is known.
+func addSourceDateEpochEnvVar(build *buildv1.Build, envVars *[]corev1.EnvVar) {
+	var epoch time.Time
+	if build.Spec.Revision != nil && build.Spec.Revision.Git != nil && len(build.Spec.Revision.Git.Commit) > 0 {
+		if v, ok := build.Annotations[CommitDateAnnotation]; ok {
+			date, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				klog.V(4).Infof("Ignoring invalid commit date %q of build %s/%s: %v", v, build.Namespace, build.Name, err)
+			} else {
+				epoch = date
+			}
+		}
+	}
+	if epoch.IsZero() {
+		epoch = build.CreationTimestamp.Time
+	}
+	if epoch.IsZero() {
+		return
+	}
+	*envVars = append(*envVars, corev1.EnvVar{Name: "SOURCE_DATE_EPOCH", Value: strconv.FormatInt(epoch.Unix(), 10)})
+}
+
+// addLockTokenEnvVar sets the BUILD_LOCK_TOKEN environment variable to the given token,
+// or to a generated unique token if requested.
+func addLockTokenEnvVar(token string, generate bool, envVars *[]corev1.EnvVar) {
+	if len(token) == 0 && generate {
+		token = string(uuid.NewUUID())
+	}
+	if len(token) == 0 {
+		return
+	}
+	*envVars = append(*envVars, corev1.EnvVar{Name: "BUILD_LOCK_TOKEN", Value: token})
+}
+
+// addPushConcurrencyEnvVar sets the BUILD_PUSH_CONCURRENCY environment variable to the
+// given concurrency, or to the default if it is zero.
+func addPushConcurrencyEnvVar(concurrency int, envVars *[]corev1.EnvVar) error {
+	if concurrency < 0 {
+		return &FatalError{fmt.Sprintf("push concurrency must be a positive integer, got %d", concurrency)}
+	}
+	if concurrency == 0 {
+		concurrency = defaultPushConcurrency
+	}
+	*envVars = append(*envVars, corev1.EnvVar{Name: "BUILD_PUSH_CONCURRENCY", Value: strconv.Itoa(concurrency)})
+	return nil
+}
+
+// addManifestAnnotationsEnvVar sets the BUILD_MANIFEST_ANNOTATIONS environment variable to
+// the JSON encoded list of annotations, which keeps names and values containing
+// special characters such as colons and slashes intact.
+func addManifestAnnotationsEnvVar(annotations []buildv1.ImageLabel, envVars *[]corev1.EnvVar) error {
+	if len(annotations) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(annotations)
+	if err != nil {
+		return err
+	}
+	*envVars = append(*envVars, corev1.EnvVar{Name: "BUILD_MANIFEST_ANNOTATIONS", Value: string(data)})
+	return nil
+}
+
+// addImageLabelsEnvVar sets the BUILD_IMAGE_LABELS environment variable to the JSON
+// encoded list of output image labels, with the extra labels added by the build
+// controller replacing the output labels of the same name. The variable is only
+// set when there are extra labels.
+func addImageLabelsEnvVar(outputLabels, extraLabels []buildv1.ImageLabel, envVars *[]corev1.EnvVar) error {
+	if len(extraLabels) == 0 {
+		return nil
+	}
+	names := sets.NewString()
+	for _, l := range extraLabels {
+		names.Insert(l.Name)
+	}
+	labels := []buildv1.ImageLabel{}
+	for _, l := range outputLabels {
+		if !names.Has(l.Name) {
+			labels = append(labels, l)
+		}
+	}
+	labels = append(labels, extraLabels...)
+	data, err := json.Marshal(labels)
+	if err != nil {
+		return err
+	}
+	*envVars = append(*envVars, corev1.EnvVar{Name: "BUILD_IMAGE_LABELS", Value: string(data)})
+	return nil
+}
+
+// buildLabelImageLabels returns the image labels copied from the given build labels,
+// omitting the labels the build does not carry.
+func buildLabelImageLabels(build *buildv1.Build, labels []string) []buildv1.ImageLabel {
+	var imageLabels []buildv1.ImageLabel
+	for _, l := range labels {
+		if v, ok := build.Labels[l]; ok {
+			imageLabels = append(imageLabels, buildv1.ImageLabel{Name: l, Value: v})
+		}
+	}
+	return imageLabels
+}
+
+// traceImageLabels returns the image label linking the output image to the trace
+// of the build, if the build carries a trace context in the given annotation.
+func traceImageLabels(build *buildv1.Build, annotation, label string) []buildv1.ImageLabel {
+	if len(annotation) == 0 || len(label) == 0 {
+		return nil
+	}
+	trace := strings.TrimSpace(build.Annotations[annotation])
+	if len(trace) == 0 {
+		return nil
+	}
+	return []buildv1.ImageLabel{{Name: label, Value: trace}}
+}
+
+// validateImageRegistry returns a FatalError if the registry of the given image is
+// not one of the allowed registries. Images without a registry are assumed to
+// come from Docker Hub.
+func validateImageRegistry(image string, allowed []string) error {
+	ref, err := reference.Parse(image)
+	if err != nil {
+		return &FatalError{fmt.Sprintf("failed to parse image %q: %v", image, err)}
+	}
+	registry := ref.DockerClientDefaults().Registry
+	for _, r := range allowed {
+		if registry == r || (reference.IsRegistryDockerHub(registry) && reference.IsRegistryDockerHub(r)) {
+			return nil
+		}
+	}
+	return &FatalError{fmt.Sprintf("image %q is pulled from registry %q which is not allowed", image, registry)}
+}
+
+// validateImageTag returns a FatalError if the image is referenced by one of the
+// disallowed tags. Images without a tag or digest use the latest tag.
+func validateImageTag(image string, disallowed []string) error {
+	ref, err := reference.Parse(image)
+	if err != nil {
+		return &FatalError{fmt.Sprintf("failed to parse image %q: %v", image, err)}
+	}
+	if len(ref.ID) > 0 {
+		return nil
+	}
+	tag := ref.DockerClientDefaults().Tag
+	for _, t := range disallowed {
+		if tag == t {
+			return &FatalError{fmt.Sprintf("image %q uses the mutable tag %q which is not allowed, reference the image by digest or by a pinned tag instead", image, tag)}
+		}
+	}
+	return nil
+}
+
+// validateCompletionDeadline returns a FatalError if the build does not declare a
+// positive completionDeadlineSeconds.
+func validateCompletionDeadline(build *buildv1.Build) error {
+	if build.Spec.CompletionDeadlineSeconds == nil || *build.Spec.CompletionDeadlineSeconds <= 0 {
+		return &FatalError{fmt.Sprintf("build %s/%s must set completionDeadlineSeconds", build.Namespace, build.Name)}
+	}
+	return nil
+}
+
+// validateResourceLimits returns a FatalError naming the first of the cpu and memory
+// limits the build container does not set.
+func validateResourceLimits(build *buildv1.Build, container *corev1.Container) error {
+	for _, name := range []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory} {
+		if limit, ok := container.Resources.Limits[name]; !ok || limit.IsZero() {
+			return &FatalError{fmt.Sprintf("build %s/%s must set a %s limit", build.Namespace, build.Name, name)}
+		}
+	}
+	return nil
+}
+
+// outputEnvVars are the environment variables holding the output destination of the
+// build.
+var outputEnvVars = sets.NewString("OUTPUT_REGISTRY", "OUTPUT_IMAGE")
+
+// validateSingleOutput returns a FatalError if the build does not push to exactly one
+// destination resolved to an image.
+func validateSingleOutput(build *buildv1.Build) error {
+	output := build.Spec.Output
+	for _, env := range build.Spec.Strategy.CustomStrategy.Env {
+		if outputEnvVars.Has(env.Name) {
+			return &FatalError{fmt.Sprintf("build %s/%s cannot set its output destination with the %s environment variable", build.Namespace, build.Name, env.Name)}
+		}
+	}
+	if output.To == nil {
+		if output.PushSecret != nil || len(output.ImageLabels) > 0 {
+			return &FatalError{fmt.Sprintf("build %s/%s sets output options without an output destination", build.Namespace, build.Name)}
+		}
+		return nil
+	}
+	if output.To.Kind != "DockerImage" || len(output.To.Name) == 0 {
+		return &FatalError{fmt.Sprintf("output %s %q of build %s/%s is not resolved to an image", output.To.Kind, output.To.Name, build.Namespace, build.Name)}
+	}
+	return nil
+}
+
+// validateImageSourcePaths returns a FatalError if the destination directory of an
+// input image path is the same as, or nested in, the destination directory of
+// another one.
+func validateImageSourcePaths(imageSources []buildv1.ImageSource) error {
+	var dirs []string
+	for _, imageSource := range imageSources {
+		for _, p := range imageSource.Paths {
+			dirs = append(dirs, filepath.Clean(p.DestinationDir))
+		}
+	}
+	for i := range dirs {
+		for j := i + 1; j < len(dirs); j++ {
+			if isSubpath(dirs[i], dirs[j]) || isSubpath(dirs[j], dirs[i]) {
+				return &FatalError{fmt.Sprintf("input image destination directories %q and %q overlap", dirs[i], dirs[j])}
+			}
+		}
+	}
+	return nil
+}
+
+// validateImageSourceCount returns a FatalError if the build references more input
+// images than allowed. A maximum of zero uses the default.
+func validateImageSourceCount(imageSources []buildv1.ImageSource, max int) error {
+	if max <= 0 {
+		max = defaultMaxImageSources
+	}
+	if len(imageSources) > max {
+		return &FatalError{fmt.Sprintf("the build references %d input images, the maximum is %d", len(imageSources), max)}
+	}
+	return nil
+}
+
+// validateSecretMountPaths returns a FatalError if the mount path of one of the
+// secrets is not absolute, is not within one of the allowed directories, or is the
+// same as, or nested in, the mount path of another secret.
+func validateSecretMountPaths(secrets []buildv1.SecretSpec, allowedPrefixes []string) error {
+	var paths []string
+	for _, s := range secrets {
+		if !filepath.IsAbs(s.MountPath) {
+			return &FatalError{fmt.Sprintf("secret %q must be mounted at an absolute path, got %q", s.SecretSource.Name, s.MountPath)}
+		}
+		p := filepath.Clean(s.MountPath)
+		allowed := false
+		for _, prefix := range allowedPrefixes {
+			if isSubpath(filepath.Clean(prefix), p) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return &FatalError{fmt.Sprintf("secret %q cannot be mounted at %q, allowed directories are %s", s.SecretSource.Name, s.MountPath, strings.Join(allowedPrefixes, ", "))}
+		}
+		for _, other := range paths {
+			if isSubpath(other, p) || isSubpath(p, other) {
+				return &FatalError{fmt.Sprintf("secret mount paths %q and %q overlap", other, p)}
+			}
+		}
+		paths = append(paths, p)
+	}
+	return nil
+}
+
+// isSubpath returns true if path is the same as, or nested in, parent.
+func isSubpath(parent, path string) bool {
+	rel, err := filepath.Rel(parent, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, "../")
+}
+
+// defaultAllowedMountPathPrefixes are the fixed paths the build controller mounts volumes
+// at in build pods. The paths which depend on the strategy configuration or on the build
+// are added to them by allowedMountPathPrefixes.
+var defaultAllowedMountPathPrefixes = []string{
+	"/var/run/secrets/openshift.io",
+	"/var/run/configs/openshift.io",
+	buildVolumeMountPath,
+	GPGHomeMountPath,
+	buildutil.BuildBlobsContentCache,
+	"/var/lib/containers",
+	"/var/run/containers",
+	dockerSocketPath,
+	ContainersRegistriesConfPath,
+	"/etc/resolv.conf",
+}
+
+// allowedMountPathPrefixes returns defaultAllowedMountPathPrefixes together with the
+// docker socket path and the git config path of the build pod, and the given
+// configured directories which are set.
+func allowedMountPathPrefixes(container *corev1.Container, socketPath string, configuredDirs ...string) []string {
+	prefixes := append([]string{}, defaultAllowedMountPathPrefixes...)
+	prefixes = append(prefixes, socketPath, builderGitConfigPath(container))
+	for _, dir := range configuredDirs {
+		if len(dir) > 0 {
+			prefixes = append(prefixes, dir)
+		}
+	}
+	return prefixes
+}
+
+// validateMountPaths returns a FatalError if a container of the pod mounts a volume at
+// a path which is not absolute or is not within one of the allowed directories, which
+// default to defaultAllowedMountPathPrefixes.
+func validateMountPaths(pod *corev1.Pod, allowedPrefixes []string) error {
+	if len(allowedPrefixes) == 0 {
+		allowedPrefixes = defaultAllowedMountPathPrefixes
+	}
+	containers := append(append([]corev1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...)
+	for _, c := range containers {
+		for _, m := range c.VolumeMounts {
+			if !filepath.IsAbs(m.MountPath) {
+				return &FatalError{fmt.Sprintf("volume %q must be mounted at an absolute path in container %s, got %q", m.Name, c.Name, m.MountPath)}
+			}
+			p := filepath.Clean(m.MountPath)
+			allowed := false
+			for _, prefix := range allowedPrefixes {
+				if isSubpath(filepath.Clean(prefix), p) {
+					allowed = true
+					break
+				}
+			}
+			if !allowed {
+				return &FatalError{fmt.Sprintf("volume %q cannot be mounted at %q in container %s, allowed directories are %s", m.Name, m.MountPath, c.Name, strings.Join(allowedPrefixes, ", "))}
+			}
+		}
+	}
+	return nil
+}
+
+// referencedSecretNames returns the names of the secrets referenced by the build, in
+// the order they are referenced and without duplicates.
+func referencedSecretNames(build *buildv1.Build, pullSecret *corev1.LocalObjectReference) []string {
+	var names []string
+	seen := sets.NewString()
+	add := func(ref *corev1.LocalObjectReference) {
+		if ref == nil || len(ref.Name) == 0 || seen.Has(ref.Name) {
+			return
+		}
+		seen.Insert(ref.Name)
+		names = append(names, ref.Name)
+	}
+	add(build.Spec.Source.SourceSecret)
+	for i := range build.Spec.Source.Secrets {
+		add(&build.Spec.Source.Secrets[i].Secret)
+	}
+	for i := range build.Spec.Source.Images {
+		add(build.Spec.Source.Images[i].PullSecret)
+	}
+	add(pullSecret)
+	add(build.Spec.Output.PushSecret)
+	if build.Spec.Strategy.CustomStrategy != nil {
+		for i := range build.Spec.Strategy.CustomStrategy.Secrets {
+			add(&build.Spec.Strategy.CustomStrategy.Secrets[i].SecretSource)
+		}
+	}
+	return names
+}
+
+// validateReferencedSecrets returns a FatalError naming the first secret referenced by
+// the build which does not exist in the namespace of the build.
+func validateReferencedSecrets(lister v1lister.SecretLister, build *buildv1.Build, pullSecret *corev1.LocalObjectReference) error {
+	if lister == nil {
+		return fmt.Errorf("secrets referenced by build %s/%s cannot be validated without a secret lister", build.Namespace, build.Name)
+	}
+	for _, name := range referencedSecretNames(build, pullSecret) {
+		_, err := lister.Secrets(build.Namespace).Get(name)
+		if kerrors.IsNotFound(err) {
+			return &FatalError{fmt.Sprintf("secret %q referenced by the build does not exist in namespace %s", name, build.Namespace)}
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get secret %s/%s: %v", build.Namespace, name, err)
+		}
+	}
+	return nil
+}
+
+// setupNodeSelectorPolicy merges the node selector configured for the namespace of the
+// pod in the given policy ConfigMap into the pod node selector. The pod is left
+// unchanged if the policy, or the namespace entry in it, does not exist.
+func setupNodeSelectorPolicy(pod *corev1.Pod, lister v1lister.ConfigMapLister, namespace, name string) error {
+	if lister == nil {
+		return fmt.Errorf("node selector policy %s/%s is configured without a ConfigMap lister", namespace, name)
+	}
+	policy, err := lister.ConfigMaps(namespace).Get(name)
+	if kerrors.IsNotFound(err) {
+		klog.V(4).Infof("Node selector policy %s/%s not found, using the build node selector", namespace, name)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get node selector policy %s/%s: %v", namespace, name, err)
+	}
+	value, ok := policy.Data[pod.Namespace]
+	if !ok {
+		return nil
+	}
+	selector, err := labels.ConvertSelectorToLabelsMap(value)
+	if err != nil {
+		return &FatalError{fmt.Sprintf("invalid node selector %q for namespace %s in policy %s/%s: %v", value, pod.Namespace, namespace, name, err)}
+	}
+
+	// the pod node selector is shared with the build, so it must not be modified
+	nodeSelector := make(map[string]string, len(pod.Spec.NodeSelector)+len(selector))
+	for k, v := range pod.Spec.NodeSelector {
+		nodeSelector[k] = v
+	}
+	for k, v := range selector {
+		nodeSelector[k] = v
+	}
+	pod.Spec.NodeSelector = nodeSelector
+	return nil
+}
+
+// buildEnvironment returns the value of the environment label of the namespace, or
+// an empty string if the namespace or the label does not exist.
+func buildEnvironment(lister v1lister.NamespaceLister, namespace, label string) (string, error) {
+	if lister == nil {
+		return "", fmt.Errorf("the environment label %s is configured without a namespace lister", label)
+	}
+	ns, err := lister.Get(namespace)
+	if kerrors.IsNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get namespace %s: %v", namespace, err)
+	}
+	return ns.Labels[label], nil
+}
+
+// validateImageArchitecture returns a FatalError if the image is not available for the
+// architecture selected by the node selector of the pod. Pods which do not select an
+// architecture, and images whose architectures are not known, are not validated.
+func validateImageArchitecture(pod *corev1.Pod, resolver ImageMetadataResolver, image string) error {
+	arch, ok := pod.Spec.NodeSelector[corev1.LabelArchStable]
+	if !ok {
+		return nil
+	}
+	architectures, err := resolver.ResolveImageArchitectures(pod.Namespace, image)
+	if err != nil {
+		return fmt.Errorf("failed to resolve the architectures of image %q: %v", image, err)
+	}
+	if len(architectures) == 0 || sets.NewString(architectures...).Has(arch) {
+		return nil
+	}
+	return &FatalError{fmt.Sprintf("image %q is available for %s, but the build runs on %s nodes", image, strings.Join(architectures, ", "), arch)}
+}
+
+// setupActiveDeadline sets up the Pod activeDeadlineSeconds field
+func setupActiveDeadline(pod *corev1.Pod, build *buildv1.Build) *corev1.Pod {
+	if build.Spec.CompletionDeadlineSeconds != nil {
+		pod.Spec.ActiveDeadlineSeconds = build.Spec.CompletionDeadlineSeconds
+		return pod
+	}
+
+	// RunOnceDuration admission plugin was used to include the default active deadline for run-once pods, like the build pods
+	// but it was removed from OpenShift in 4.0; rather than ship the RunOnceDuration admission as webhook admission
+	// plugin, which will involve creating new operator, we use a long activeDeadlineSeconds as build are
+	// designed to terminate
+	var defActiveDeadline int64
+	defActiveDeadline = 604800 // 1 week = 60 sec * 60 min * 24 hr * 7 days
+	pod.Spec.ActiveDeadlineSeconds = &defActiveDeadline
+	return pod
+}
+
+// deadlineWatcherResources are the resources requested by the deadline watcher sidecar,
+// and its limits.
+var deadlineWatcherResources = corev1.ResourceList{
+	corev1.ResourceCPU:    resource.MustParse("10m"),
+	corev1.ResourceMemory: resource.MustParse("32Mi"),
+}
+
+// setupDeadlineWatcher adds a sidecar running the given image to the pod, which is
+// passed the active deadline of the pod in BUILD_DEADLINE_SECONDS. The pod shares
+// its process namespace so the sidecar can signal the builder, which only needs the
+// KILL capability. The sidecar requests small fixed resources equal to its limits, so
+// it does not lower the QoS class of the pod. The pod only completes once the sidecar
+// exits, so it must exit as soon as the builder process does.
+func setupDeadlineWatcher(pod *corev1.Pod, image string) {
+	if pod.Spec.ActiveDeadlineSeconds == nil {
+		return
+	}
+	shareProcessNamespace := true
+	pod.Spec.ShareProcessNamespace = &shareProcessNamespace
+	allowPrivilegeEscalation := false
+	readOnlyRootFilesystem := true
+	pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{
+		Name:  DeadlineWatcherContainer,
+		Image: image,
+		Env: []corev1.EnvVar{
+			{Name: "BUILD_DEADLINE_SECONDS", Value: strconv.FormatInt(*pod.Spec.ActiveDeadlineSeconds, 10)},
+		},
+		Resources: corev1.ResourceRequirements{
+			Requests: deadlineWatcherResources.DeepCopy(),
+			Limits:   deadlineWatcherResources.DeepCopy(),
+		},
+		SecurityContext: &corev1.SecurityContext{
+			AllowPrivilegeEscalation: &allowPrivilegeEscalation,
+			ReadOnlyRootFilesystem:   &readOnlyRootFilesystem,
+			Capabilities: &corev1.Capabilities{
+				Drop: []corev1.Capability{"ALL"},
+				Add:  []corev1.Capability{"KILL"},
+			},
+		},
+		ImagePullPolicy:          corev1.PullIfNotPresent,
+		TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+	})
+	klog.V(3).Infof("Added the deadline watcher sidecar to pod %s/%s", pod.Namespace, pod.Name)
+}
+
+// setupTerminationGracePeriod sets the pod terminationGracePeriodSeconds based on the
+// estimated output image size, so that builders pushing large images get more time
+// to shut down. Sizes are counted in whole Gi, and the grace period is bounded by
+// maxSeconds.
+func setupTerminationGracePeriod(pod *corev1.Pod, build *buildv1.Build, secondsPerGi, maxSeconds int64) {
+	if secondsPerGi <= 0 {
+		return
+	}
+	gracePeriod := defaultTerminationGracePeriodSeconds
+	if size, err := resource.ParseQuantity(build.Annotations[imageSizeAnnotation]); err == nil && size.Sign() > 0 {
+		gi := size.Value() / (1 << 30)
+		// avoid overflowing the grace period for sizes set by the build author
+		if limit := (math.MaxInt64 - gracePeriod) / secondsPerGi; gi > limit {
+			gi = limit
+		}
+		if gi > 0 {
+			gracePeriod += secondsPerGi * gi
+		}
+	}
+	if maxSeconds > 0 && gracePeriod > maxSeconds {
+		gracePeriod = maxSeconds
+	}
+	pod.Spec.TerminationGracePeriodSeconds = &gracePeriod
+}
+
+// setupAdditionalSecrets creates secret volume mounts in the given pod for the given list of secrets
+func setupAdditionalSecrets(pod *corev1.Pod, container *corev1.Container, secrets []buildv1.SecretSpec) {
+	for _, secretSpec := range secrets {
+		mountSecretVolume(pod, container, secretSpec.SecretSource.Name, secretSpec.MountPath, "secret", nil)
+		klog.V(3).Infof("Installed additional secret in %s, in Pod %s/%s", secretSpec.MountPath, pod.Namespace, pod.Name)
+	}
+}
+
+// validateSecretPathConflicts returns a FatalError if a secret is mounted both as an
+// input secret and as an additional secret, at different paths.
+func validateSecretPathConflicts(inputSecrets []buildv1.SecretBuildSource, secrets []buildv1.SecretSpec) error {
+	inputPaths := map[string]string{}
+	for _, s := range inputSecrets {
+		inputPaths[s.Secret.Name] = filepath.Join(SecretBuildSourceBaseMountPath, s.Secret.Name)
+	}
+	for _, s := range secrets {
+		inputPath, ok := inputPaths[s.SecretSource.Name]
+		if ok && inputPath != filepath.Clean(s.MountPath) {
+			return &FatalError{fmt.Sprintf("secret %q is mounted at both %q and %q", s.SecretSource.Name, inputPath, s.MountPath)}
+		}
+	}
+	return nil
+}
+
+// setupAggregatedSecrets mounts the input secrets and the additional secrets of the
+// build in subdirectories of dir named after each secret, and exposes dir to the
+// builder in the BUILD_SECRETS_DIR environment variable. Secrets which would be
+// mounted at the same path are rejected.
+func setupAggregatedSecrets(pod *corev1.Pod, container *corev1.Container, dir string, inputSecrets []buildv1.SecretBuildSource, secrets []buildv1.SecretSpec) error {
+	names := []string{}
+	for _, s := range inputSecrets {
+		names = append(names, s.Secret.Name)
+	}
+	for _, s := range secrets {
+		names = append(names, s.SecretSource.Name)
+	}
+	if len(names) == 0 {
+		return nil
+	}
+
+	seen := sets.NewString()
+	for _, name := range names {
+		if seen.Has(name) {
+			return &FatalError{fmt.Sprintf("secret %q is mounted more than once in %s", name, dir)}
+		}
+		seen.Insert(name)
+	}
+	for _, name := range names {
+		mountSecretVolume(pod, container, name, filepath.Join(dir, name), "build", nil)
+		klog.V(3).Infof("%s will be used as a build secret in %s", name, dir)
+	}
+	container.Env = append(container.Env, corev1.EnvVar{Name: "BUILD_SECRETS_DIR", Value: dir})
+	return nil
+}
+
+// setupCriticalBuildAnnotations stamps the configured critical build annotations
+// on the pod if the build opted in via the critical build annotation.
+func setupCriticalBuildAnnotations(pod *corev1.Pod, build *buildv1.Build, annotations map[string]string) {
+	if len(annotations) == 0 {
+		return
+	}
+	if critical, err := strconv.ParseBool(build.Annotations[criticalBuildAnnotation]); err != nil || !critical {
+		return
+	}
+	for k, v := range annotations {
+		metav1.SetMetaDataAnnotation(&pod.ObjectMeta, k, v)
+	}
+	klog.V(4).Infof("Build %s/%s is marked as critical", build.Namespace, build.Name)
+}
+
+// setupServiceAccountToken projects a token for the build's service account with the
+// requested audience into the builder container. When allowOverride is set, the build
+// annotation takes precedence over the default audience.
+func setupServiceAccountToken(pod *corev1.Pod, container *corev1.Container, build *buildv1.Build, defaultAudience string, allowOverride bool) {
+	audience := defaultAudience
+	if v, ok := build.Annotations[tokenAudienceAnnotation]; ok && len(v) > 0 && allowOverride {
+		audience = v
+	}
+	if len(audience) == 0 {
+		return
+	}
+
+	const volumeName = "build-service-account-token"
+	pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+		Name: volumeName,
+		VolumeSource: corev1.VolumeSource{
+			Projected: &corev1.ProjectedVolumeSource{
+				Sources: []corev1.VolumeProjection{
+					{
+						ServiceAccountToken: &corev1.ServiceAccountTokenProjection{
+							Audience: audience,
+							Path:     "token",
+						},
+					},
+				},
+			},
+		},
+	})
+	container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+		Name:      volumeName,
+		MountPath: ServiceAccountTokenMountPath,
+		ReadOnly:  true,
+	})
+	container.Env = append(container.Env, corev1.EnvVar{Name: "BUILD_TOKEN_PATH", Value: filepath.Join(ServiceAccountTokenMountPath, "token")})
+	klog.V(3).Infof("Projected service account token with audience %q in Pod %s/%s", audience, pod.Namespace, pod.Name)
+}
+
+// setupBuildCache passes the remote build cache location to the container and mounts
+// the credentials for it.
+func setupBuildCache(pod *corev1.Pod, container *corev1.Container, cacheURL string, cacheSecret *corev1.LocalObjectReference) error {
+	if len(cacheURL) == 0 {
+		return nil
+	}
+	if u, err := url.Parse(cacheURL); err != nil || len(u.Scheme) == 0 || len(u.Host) == 0 {
+		return &FatalError{fmt.Sprintf("invalid build cache URL %q", cacheURL)}
+	}
+	container.Env = append(container.Env, corev1.EnvVar{Name: "BUILD_CACHE_URL", Value: cacheURL})
+	if cacheSecret != nil {
+		mountSecretVolume(pod, container, cacheSecret.Name, BuildCacheSecretMountPath, "build-cache", nil)
+		container.Env = append(container.Env, corev1.EnvVar{Name: "BUILD_CACHE_CREDENTIALS_PATH", Value: BuildCacheSecretMountPath})
+		klog.V(3).Infof("%s will be used for the build cache in %s", BuildCacheSecretMountPath, pod.Name)
+	}
+	return nil
+}
+
+// setupGPGKeyring mounts the GPG keyring secret read-only into the container and
+// points BUILD_GPG_KEYRING_DIR at it. gpg needs to write to its home directory, so
+// GNUPGHOME points at an empty writable directory instead, and the builder is expected
+// to import the keys from BUILD_GPG_KEYRING_DIR into it before signing.
+func setupGPGKeyring(pod *corev1.Pod, container *corev1.Container, keyringSecret *corev1.LocalObjectReference) error {
+	if keyringSecret == nil {
+		return nil
+	}
+	if len(keyringSecret.Name) == 0 {
+		return &FatalError{"the GPG keyring secret must be named"}
+	}
+	mountSecretVolume(pod, container, keyringSecret.Name, GPGKeyringMountPath, "gpg", nil)
+
+	const homeVolumeName = "build-gnupg-home"
+	pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+		Name: homeVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			EmptyDir: &corev1.EmptyDirVolumeSource{},
+		},
+	})
+	container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+		Name:      homeVolumeName,
+		MountPath: GPGHomeMountPath,
+	})
+	container.Env = append(container.Env,
+		corev1.EnvVar{Name: "GNUPGHOME", Value: GPGHomeMountPath},
+		corev1.EnvVar{Name: "BUILD_GPG_KEYRING_DIR", Value: GPGKeyringMountPath},
+	)
+	klog.V(3).Infof("%s will be used as the GPG keyring in %s", keyringSecret.Name, GPGKeyringMountPath)
+	return nil
+}
+
+// builderGitConfigPath returns the path of the global git config file of the builder,
+// which is in the HOME directory set in the environment of the container.
+func builderGitConfigPath(container *corev1.Container) string {
+	home := defaultBuilderHome
+	for _, env := range container.Env {
+		if env.Name == "HOME" && len(env.Value) > 0 {
+			home = env.Value
+		}
+	}
+	return filepath.Join(home, ".gitconfig")
+}
+
+// setupGitConfig mounts the given ConfigMap key as .gitconfig in the home directory
+// of the container and points GIT_CONFIG_GLOBAL at it.
+func setupGitConfig(pod *corev1.Pod, container *corev1.Container, gitConfig *corev1.ConfigMapKeySelector, lister v1lister.ConfigMapLister) error {
+	if gitConfig == nil {
+		return nil
+	}
+	if len(gitConfig.Name) == 0 || len(gitConfig.Key) == 0 {
+		return &FatalError{"the git config ConfigMap name and key must be set"}
+	}
+	if err := validateConfigMapKey(lister, pod.Namespace, gitConfig, "git config"); err != nil {
+		return err
+	}
+
+	gitConfigPath := builderGitConfigPath(container)
+
+	const volumeName = "build-git-config"
+	pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+		Name: volumeName,
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: gitConfig.LocalObjectReference,
+				Items: []corev1.KeyToPath{
+					{
+						Key:  gitConfig.Key,
+						Path: ".gitconfig",
+					},
+				},
+			},
+		},
+	})
+	container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+		Name:      volumeName,
+		MountPath: gitConfigPath,
+		SubPath:   ".gitconfig",
+		ReadOnly:  true,
+	})
+	container.Env = append(container.Env, corev1.EnvVar{Name: "GIT_CONFIG_GLOBAL", Value: gitConfigPath})
+	return nil
+}
+
+// setupDownwardAPIVolume exposes the labels and annotations of the pod as the labels
+// and annotations files of the given directory in the container.
+func setupDownwardAPIVolume(pod *corev1.Pod, container *corev1.Container, mountPath string) error {
+	if len(mountPath) == 0 {
+		return nil
+	}
+	if !filepath.IsAbs(mountPath) {
+		return &FatalError{fmt.Sprintf("the build metadata mount path %q must be absolute", mountPath)}
+	}
+
+	const volumeName = "build-metadata"
+	pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+		Name: volumeName,
+		VolumeSource: corev1.VolumeSource{
+			DownwardAPI: &corev1.DownwardAPIVolumeSource{
+				Items: []corev1.DownwardAPIVolumeFile{
+					{
+						Path:     "labels",
+						FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.labels"},
+					},
+					{
+						Path:     "annotations",
+						FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.annotations"},
+					},
+				},
+			},
+		},
+	})
+	container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+		Name:      volumeName,
+		MountPath: mountPath,
+		ReadOnly:  true,
+	})
+	return nil
+}
+
+// validateConfigMapKey returns a FatalError if the ConfigMap key selected for the
+// given purpose does not exist in the namespace. The check is skipped when no
+// ConfigMap lister is configured, in which case the kubelet fails to start the pod.
+func validateConfigMapKey(lister v1lister.ConfigMapLister, namespace string, selector *corev1.ConfigMapKeySelector, purpose string) error {
+	if lister == nil {
+		return nil
+	}
+	cm, err := lister.ConfigMaps(namespace).Get(selector.Name)
+	if kerrors.IsNotFound(err) {
+		return &FatalError{fmt.Sprintf("%s ConfigMap %s/%s not found", purpose, namespace, selector.Name)}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get %s ConfigMap %s/%s: %v", purpose, namespace, selector.Name, err)
+	}
+	if _, ok := cm.Data[selector.Key]; !ok {
+		return &FatalError{fmt.Sprintf("%s ConfigMap %s/%s has no key %q", purpose, namespace, selector.Name, selector.Key)}
+	}
+	return nil
+}
+
+// setupRegistriesConf mounts the given ConfigMap key as the containers registries.conf
+// of the container and points CONTAINERS_REGISTRIES_CONF at it. If a lister is given,
+// the ConfigMap is verified to have the key.
+func setupRegistriesConf(pod *corev1.Pod, container *corev1.Container, registriesConf *corev1.ConfigMapKeySelector, lister v1lister.ConfigMapLister) error {
+	if registriesConf == nil {
+		return nil
+	}
+	if len(registriesConf.Name) == 0 || len(registriesConf.Key) == 0 {
+		return &FatalError{"the registries.conf ConfigMap name and key must be set"}
+	}
+	if err := validateConfigMapKey(lister, pod.Namespace, registriesConf, "registries.conf"); err != nil {
+		return err
+	}
+
+	const volumeName = "build-registries-conf"
+	pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+		Name: volumeName,
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: registriesConf.LocalObjectReference,
+				Items: []corev1.KeyToPath{
+					{
+						Key:  registriesConf.Key,
+						Path: "registries.conf",
+					},
+				},
+			},
+		},
+	})
+	container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+		Name:      volumeName,
+		MountPath: ContainersRegistriesConfPath,
+		SubPath:   "registries.conf",
+		ReadOnly:  true,
+	})
+	container.Env = append(container.Env, corev1.EnvVar{Name: "CONTAINERS_REGISTRIES_CONF", Value: ContainersRegistriesConfPath})
+	return nil
+}
+
+// setupResolvConf mounts the given ConfigMap key over the container's /etc/resolv.conf.
+func setupResolvConf(pod *corev1.Pod, container *corev1.Container, resolvConf *corev1.ConfigMapKeySelector, lister v1lister.ConfigMapLister) error {
+	if resolvConf == nil {
+		return nil
 	}
-	mode := int32(0o600)
-	if container.SecurityContext == nil || container.SecurityContext.Privileged == nil || !*container.SecurityContext.Privileged {
-		mode = int32(0o644) // make sure unprivileged builders can read them
+	if len(resolvConf.Name) == 0 || len(resolvConf.Key) == 0 {
+		return &FatalError{"the resolv.conf ConfigMap name and key must be set"}
 	}
-	if !volumeExists {
-		volume := makeVolume(volumeName, objName, mode, fsType, volumeSource)
-		pod.Spec.Volumes = append(pod.Spec.Volumes, volume)
+	if err := validateConfigMapKey(lister, pod.Namespace, resolvConf, "resolv.conf"); err != nil {
+		return err
 	}
 
-	volumeMount := corev1.VolumeMount{
+	const volumeName = "build-resolv-conf"
+	pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+		Name: volumeName,
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: resolvConf.LocalObjectReference,
+				Items: []corev1.KeyToPath{
+					{
+						Key:  resolvConf.Key,
+						Path: "resolv.conf",
+					},
+				},
+			},
+		},
+	})
+	container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
 		Name:      volumeName,
-		MountPath: mountPath,
+		MountPath: "/etc/resolv.conf",
+		SubPath:   "resolv.conf",
 		ReadOnly:  true,
+	})
+	return nil
+}
+
+// validateResolvConfDNSPolicy returns a FatalError if the pod uses the None DNS policy
+// with an explicit DNS config, as it then gets its resolv.conf from the kubelet and the
+// mounted one would conflict with it. The DNS settings may come from build defaults or
+// overrides, so this runs once they are applied.
+func validateResolvConfDNSPolicy(pod *corev1.Pod) error {
+	if pod.Spec.DNSPolicy == corev1.DNSNone && pod.Spec.DNSConfig != nil {
+		return &FatalError{fmt.Sprintf("resolv.conf cannot be mounted for pod %s/%s using DNS policy %s with a DNS config", pod.Namespace, pod.Name, corev1.DNSNone)}
 	}
-	container.VolumeMounts = append(container.VolumeMounts, volumeMount)
+	return nil
 }
 
-func makeVolume(volumeName, refName string, mode int32, fsType policy.FSType, volumeSource *corev1.VolumeSource) corev1.Volume {
-	// TODO: Add support for key-based paths for secrets and configMaps?
-	vol := corev1.Volume{
-		Name:         volumeName,
-		VolumeSource: corev1.VolumeSource{},
+// setupBuildAttemptAnnotation stamps the build attempt annotation on the pod. The
+// attempt is carried over from the build when it was already tracked there,
+// otherwise the pod is the first attempt.
+func setupBuildAttemptAnnotation(pod *corev1.Pod, build *buildv1.Build) {
+	attempt := 1
+	if v, err := strconv.Atoi(build.Annotations[BuildAttemptAnnotation]); err == nil && v > 0 {
+		attempt = v
 	}
-	switch fsType {
-	case policy.ConfigMap:
-		if volumeSource != nil && volumeSource.ConfigMap != nil {
-			vol.VolumeSource.ConfigMap = volumeSource.ConfigMap.DeepCopy()
-		} else {
-			vol.VolumeSource.ConfigMap = &corev1.ConfigMapVolumeSource{
-				LocalObjectReference: corev1.LocalObjectReference{
-					Name: refName,
+	metav1.SetMetaDataAnnotation(&pod.ObjectMeta, BuildAttemptAnnotation, strconv.Itoa(attempt))
+}
+
+// setupEntrypointWrapper mounts the given executable ConfigMap key into the container
+// and makes it the container command, passing the given builder entrypoint to it as
+// arguments.
+func setupEntrypointWrapper(pod *corev1.Pod, container *corev1.Container, wrapper *corev1.ConfigMapKeySelector, entrypoint []string, lister v1lister.ConfigMapLister) error {
+	if wrapper == nil {
+		return nil
+	}
+	if len(wrapper.Name) == 0 || len(wrapper.Key) == 0 {
+		return &FatalError{"the entrypoint wrapper ConfigMap name and key must be set"}
+	}
+	if len(entrypoint) == 0 {
+		return &FatalError{"the entrypoint wrapper requires the builder entrypoint command to be set"}
+	}
+	if err := validateConfigMapKey(lister, pod.Namespace, wrapper, "entrypoint wrapper"); err != nil {
+		return err
+	}
+
+	const volumeName = "build-entrypoint-wrapper"
+	const scriptName = "entrypoint"
+	mode := int32(0o755)
+	pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+		Name: volumeName,
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: wrapper.LocalObjectReference,
+				Items: []corev1.KeyToPath{
+					{
+						Key:  wrapper.Key,
+						Path: scriptName,
+					},
 				},
 				DefaultMode: &mode,
+			},
+		},
+	})
+	container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+		Name:      volumeName,
+		MountPath: ConfigMapEntrypointMountPath,
+		ReadOnly:  true,
+	})
+	container.Command = []string{filepath.Join(ConfigMapEntrypointMountPath, scriptName)}
+	container.Args = append([]string{}, entrypoint...)
+	return nil
+}
+
+// setupQOSClassAnnotation stamps the QoS class of the pod, computed from the
+// resources of its containers, as an annotation for reporting.
+func setupQOSClassAnnotation(pod *corev1.Pod) {
+	metav1.SetMetaDataAnnotation(&pod.ObjectMeta, QOSClassAnnotation, string(qosClassForPod(pod)))
+}
+
+// qosClassForPod returns the QoS class of the pod based on the cpu and memory
+// requests and limits of its containers. Requests which are not set default to
+// the limits, as they would when the pod is admitted.
+func qosClassForPod(pod *corev1.Pod) corev1.PodQOSClass {
+	containers := append(append([]corev1.Container{}, pod.Spec.Containers...), pod.Spec.InitContainers...)
+	bestEffort, guaranteed := true, true
+	for _, c := range containers {
+		for _, name := range []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory} {
+			limit, hasLimit := c.Resources.Limits[name]
+			request, hasRequest := c.Resources.Requests[name]
+			if (hasLimit && !limit.IsZero()) || (hasRequest && !request.IsZero()) {
+				bestEffort = false
 			}
-		}
-	case policy.EmptyDir:
-		if volumeSource != nil && volumeSource.EmptyDir != nil {
-			vol.VolumeSource.EmptyDir = volumeSource.EmptyDir.DeepCopy()
-		} else {
-			vol.VolumeSource.EmptyDir = &corev1.EmptyDirVolumeSource{}
-		}
-	case policy.Secret:
-		if volumeSource != nil && volumeSource.Secret != nil {
-			vol.VolumeSource.Secret = volumeSource.Secret.DeepCopy()
-		} else {
-			vol.VolumeSource.Secret = &corev1.SecretVolumeSource{
-				SecretName:  refName,
-				DefaultMode: &mode,
+			if !hasLimit || limit.IsZero() || (hasRequest && request.Cmp(limit) != 0) {
+				guaranteed = false
 			}
 		}
-	case policy.CSI:
-		if volumeSource != nil && volumeSource.CSI != nil {
-			vol.VolumeSource.CSI = volumeSource.CSI.DeepCopy()
-		} else {
-			vol.VolumeSource.CSI = &corev1.CSIVolumeSource{}
-		}
+	}
+	switch {
+	case bestEffort:
+		return corev1.PodQOSBestEffort
+	case guaranteed:
+		return corev1.PodQOSGuaranteed
 	default:
-		klog.V(3).Infof("File system %s is not supported for volumes. Using empty directory instead.", fsType)
-		vol.VolumeSource.EmptyDir = &corev1.EmptyDirVolumeSource{}
+		return corev1.PodQOSBurstable
 	}
-
-	return vol
 }
 
-// setupDockerSecrets mounts Docker Registry secrets into Pod running the build,
-// allowing Docker to authenticate against private registries or Docker Hub.
-func setupDockerSecrets(pod *corev1.Pod, container *corev1.Container, pushSecret, pullSecret *corev1.LocalObjectReference, imageSources []buildv1.ImageSource) {
-	if pushSecret != nil {
-		mountSecretVolume(pod, container, pushSecret.Name, DockerPushSecretMountPath, "push", nil)
-		container.Env = append(container.Env, []corev1.EnvVar{
-			{Name: "PUSH_DOCKERCFG_PATH", Value: DockerPushSecretMountPath},
-		}...)
-		klog.V(3).Infof("%s will be used for docker push in %s", DockerPushSecretMountPath, pod.Name)
+// setupSecurityPostureAnnotation stamps a summary of the security settings of the
+// build container on the pod, so they can be audited without parsing the pod spec.
+func setupSecurityPostureAnnotation(pod *corev1.Pod) {
+	privileged, runAsNonRoot, readOnlyRootFilesystem := false, false, false
+	if sc := pod.Spec.Containers[0].SecurityContext; sc != nil {
+		privileged = sc.Privileged != nil && *sc.Privileged
+		runAsNonRoot = sc.RunAsNonRoot != nil && *sc.RunAsNonRoot
+		readOnlyRootFilesystem = sc.ReadOnlyRootFilesystem != nil && *sc.ReadOnlyRootFilesystem
+	}
+	if psc := pod.Spec.SecurityContext; psc != nil && psc.RunAsNonRoot != nil && *psc.RunAsNonRoot {
+		runAsNonRoot = true
 	}
+	dockerSocket := false
+	for _, v := range pod.Spec.Volumes {
+		if v.Name == "docker-socket" {
+			dockerSocket = true
+		}
+	}
+	posture := fmt.Sprintf("privileged=%t,runAsNonRoot=%t,readOnlyRootFilesystem=%t,dockerSocket=%t", privileged, runAsNonRoot, readOnlyRootFilesystem, dockerSocket)
+	metav1.SetMetaDataAnnotation(&pod.ObjectMeta, SecurityPostureAnnotation, posture)
+}
 
-	if pullSecret != nil {
-		mountSecretVolume(pod, container, pullSecret.Name, DockerPullSecretMountPath, "pull", nil)
-		container.Env = append(container.Env, []corev1.EnvVar{
-			{Name: "PULL_DOCKERCFG_PATH", Value: DockerPullSecretMountPath},
-		}...)
-		klog.V(3).Infof("%s will be used for docker pull in %s", DockerPullSecretMountPath, pod.Name)
+// setupEnvNamesAnnotation records the sorted names of the environment variables of the
+// container on the pod. The values are left out as they may be sensitive.
+func setupEnvNamesAnnotation(pod *corev1.Pod, container *corev1.Container) {
+	names := sets.NewString()
+	for _, env := range container.Env {
+		names.Insert(env.Name)
 	}
+	metav1.SetMetaDataAnnotation(&pod.ObjectMeta, EnvNamesAnnotation, strings.Join(names.List(), ","))
+}
 
-	for i, imageSource := range imageSources {
-		if imageSource.PullSecret == nil {
-			continue
+// setupBuildChecksumAnnotation records the SHA-256 checksum of the encoded build in
+// the BUILD environment variable of the container on the pod.
+func setupBuildChecksumAnnotation(pod *corev1.Pod, container *corev1.Container) {
+	for _, env := range container.Env {
+		if env.Name == "BUILD" {
+			metav1.SetMetaDataAnnotation(&pod.ObjectMeta, BuildChecksumAnnotation, fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(env.Value))))
+			return
 		}
-		mountPath := filepath.Join(SourceImagePullSecretMountPath, strconv.Itoa(i))
-		mountSecretVolume(pod, container, imageSource.PullSecret.Name, mountPath, fmt.Sprintf("%s%d", "source-image", i), nil)
-		container.Env = append(container.Env, []corev1.EnvVar{
-			{Name: fmt.Sprintf("%s%d", "PULL_SOURCE_DOCKERCFG_PATH_", i), Value: mountPath},
-		}...)
-		klog.V(3).Infof("%s will be used for docker pull in %s", mountPath, pod.Name)
 	}
 }
 
-// setupSourceSecrets mounts SSH key used for accessing private SCM to clone
-// application source code during build.
-func setupSourceSecrets(pod *corev1.Pod, container *corev1.Container, sourceSecret *corev1.LocalObjectReference) {
-	if sourceSecret == nil {
+// getPodLabels creates labels for the Build Pod
+func getPodLabels(build *buildv1.Build) map[string]string {
+	return map[string]string{buildv1.BuildLabel: buildutil.LabelValue(build.Name)}
+}
+
+// setupCorrelationLabel copies the correlation ID from the given build annotation
+// into the given pod label.
+func setupCorrelationLabel(pod *corev1.Pod, build *buildv1.Build, annotation, label string) {
+	if len(annotation) == 0 || len(label) == 0 {
 		return
 	}
-
-	mountSecretVolume(pod, container, sourceSecret.Name, sourceSecretMountPath, "source", nil)
-	klog.V(3).Infof("Installed source secrets in %s, in Pod %s/%s", sourceSecretMountPath, pod.Namespace, pod.Name)
-	container.Env = append(container.Env, []corev1.EnvVar{
-		{Name: "SOURCE_SECRET_PATH", Value: sourceSecretMountPath},
-	}...)
+	value := sanitizeLabelValue(build.Annotations[annotation])
+	if len(value) == 0 {
+		return
+	}
+	if pod.Labels == nil {
+		pod.Labels = map[string]string{}
+	}
+	pod.Labels[label] = value
 }
 
-// setupInputConfigMaps mounts the configMaps referenced by the ConfigMapBuildSource
-// into a builder container.
-func setupInputConfigMaps(pod *corev1.Pod, container *corev1.Container, configs []buildv1.ConfigMapBuildSource) {
-	for _, c := range configs {
-		mountConfigMapVolume(pod, container, c.ConfigMap.Name, filepath.Join(ConfigMapBuildSourceBaseMountPath, c.ConfigMap.Name), "build", nil)
-		klog.V(3).Infof("%s will be used as a build config in %s", c.ConfigMap.Name, ConfigMapBuildSourceBaseMountPath)
+// buildCorrelationID returns the correlation ID held in the given build annotation, or
+// an ID derived from the build UID when the annotation is absent, so that every pod of
+// the build gets the same ID.
+func buildCorrelationID(build *buildv1.Build, annotation string) string {
+	if len(annotation) > 0 {
+		if id := build.Annotations[annotation]; len(id) > 0 {
+			return id
+		}
+	}
+	if len(build.UID) == 0 {
+		return ""
 	}
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(build.UID)))[:32]
 }
 
-// setupInputSecrets mounts the secrets referenced by the SecretBuildSource
-// into a builder container.
-func setupInputSecrets(pod *corev1.Pod, container *corev1.Container, secrets []buildv1.SecretBuildSource) {
-	for _, s := range secrets {
-		mountSecretVolume(pod, container, s.Secret.Name, filepath.Join(SecretBuildSourceBaseMountPath, s.Secret.Name), "build", nil)
-		klog.V(3).Infof("%s will be used as a build secret in %s", s.Secret.Name, SecretBuildSourceBaseMountPath)
+// setupCorrelationIDLabel sets the given correlation ID in the given pod label, or in
+// the default correlation label if none is given.
+func setupCorrelationIDLabel(pod *corev1.Pod, id, label string) {
+	if len(label) == 0 {
+		label = defaultCorrelationLabel
 	}
+	value := sanitizeLabelValue(id)
+	if len(value) == 0 {
+		return
+	}
+	if pod.Labels == nil {
+		pod.Labels = map[string]string{}
+	}
+	pod.Labels[label] = value
 }
 
-// addSourceEnvVars adds environment variables related to the source code
-// repository to builder container
-func addSourceEnvVars(source buildv1.BuildSource, output *[]corev1.EnvVar) {
-	sourceVars := []corev1.EnvVar{}
-	if source.Git != nil {
-		sourceVars = append(sourceVars, corev1.EnvVar{Name: "SOURCE_REPOSITORY", Value: source.Git.URI})
-		sourceVars = append(sourceVars, corev1.EnvVar{Name: "SOURCE_URI", Value: source.Git.URI})
+// setupQuotaLabel sets the quota label on the pod, using the default key and value
+// when they are empty. Labels already set on the pod are left unchanged.
+func setupQuotaLabel(pod *corev1.Pod, key, value string) {
+	if len(key) == 0 {
+		key = defaultQuotaLabelKey
 	}
-	if len(source.ContextDir) > 0 {
-		sourceVars = append(sourceVars, corev1.EnvVar{Name: "SOURCE_CONTEXT_DIR", Value: source.ContextDir})
+	if len(value) == 0 {
+		value = defaultQuotaLabelValue
 	}
-	if source.Git != nil && len(source.Git.Ref) > 0 {
-		sourceVars = append(sourceVars, corev1.EnvVar{Name: "SOURCE_REF", Value: source.Git.Ref})
+	if pod.Labels == nil {
+		pod.Labels = map[string]string{}
 	}
-	*output = append(*output, sourceVars...)
+	if existing, ok := pod.Labels[key]; ok {
+		klog.V(2).Infof("Not setting quota label %s=%s on pod %s/%s, the label is already set to %q", key, value, pod.Namespace, pod.Name, existing)
+		return
+	}
+	pod.Labels[key] = value
 }
 
-// addOutputEnvVars adds env variables that provide information about the output
-// target for the build
-func addOutputEnvVars(buildOutput *corev1.ObjectReference, output *[]corev1.EnvVar) error {
-	if buildOutput == nil {
-		return nil
+// setupCacheAffinity copies the cache key label of the build to the pod, and adds a
+// preferred pod affinity term scheduling the pod next to the pods of builds with
+// the same cache key.
+func setupCacheAffinity(pod *corev1.Pod, build *buildv1.Build, label string) {
+	if len(label) == 0 {
+		return
 	}
-
-	// output must always be a DockerImage type reference at this point.
-	if buildOutput.Kind != "DockerImage" {
-		return fmt.Errorf("invalid build output kind %s, must be DockerImage", buildOutput.Kind)
+	key, ok := build.Labels[label]
+	if !ok || len(key) == 0 {
+		return
 	}
-	ref, err := reference.Parse(buildOutput.Name)
-	if err != nil {
-		return err
+	if pod.Labels == nil {
+		pod.Labels = map[string]string{}
 	}
-	registry := ref.Registry
-	ref.Registry = ""
-	image := ref.String()
+	pod.Labels[label] = key
 
-	outputVars := []corev1.EnvVar{
-		{Name: "OUTPUT_REGISTRY", Value: registry},
-		{Name: "OUTPUT_IMAGE", Value: image},
+	if pod.Spec.Affinity == nil {
+		pod.Spec.Affinity = &corev1.Affinity{}
 	}
-
-	*output = append(*output, outputVars...)
-	return nil
-}
-
-// addTrustedCAMountEnvVar sets the BUILD_MOUNT_ETC_PKI_CATRUST environment variable if the build
-// pod needs the CA trust bundle (`/etc/pki/ca-trust`) mounted into build processes.
-func addTrustedCAMountEnvVar(mountTrustedCA *bool, envVars *[]corev1.EnvVar) {
-	if mountTrustedCA != nil {
-		*envVars = append(*envVars, corev1.EnvVar{Name: "BUILD_MOUNT_ETC_PKI_CATRUST", Value: strconv.FormatBool(*mountTrustedCA)})
+	if pod.Spec.Affinity.PodAffinity == nil {
+		pod.Spec.Affinity.PodAffinity = &corev1.PodAffinity{}
 	}
+	pod.Spec.Affinity.PodAffinity.PreferredDuringSchedulingIgnoredDuringExecution = append(pod.Spec.Affinity.PodAffinity.PreferredDuringSchedulingIgnoredDuringExecution, corev1.WeightedPodAffinityTerm{
+		Weight: 100,
+		PodAffinityTerm: corev1.PodAffinityTerm{
+			LabelSelector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{label: key},
+			},
+			TopologyKey: corev1.LabelHostname,
+		},
+	})
 }
 
-// setupActiveDeadline sets up the Pod activeDeadlineSeconds field
-func setupActiveDeadline(pod *corev1.Pod, build *buildv1.Build) *corev1.Pod {
-	if build.Spec.CompletionDeadlineSeconds != nil {
-		pod.Spec.ActiveDeadlineSeconds = build.Spec.CompletionDeadlineSeconds
-		return pod
+// setupBuilderImageDigestAnnotation records the digest the builder image resolves to on
+// the pod. The annotation is omitted if the image could not be resolved.
+func setupBuilderImageDigestAnnotation(pod *corev1.Pod, resolver ImageResolver, image string) {
+	digest, err := resolver.ResolveImageDigest(pod.Namespace, image)
+	if err != nil {
+		klog.V(2).Infof("Failed to resolve the builder image %q of pod %s/%s: %v", image, pod.Namespace, pod.Name, err)
+		return
 	}
-
-	// RunOnceDuration admission plugin was used to include the default active deadline for run-once pods, like the build pods
-	// but it was removed from OpenShift in 4.0; rather than ship the RunOnceDuration admission as webhook admission
-	// plugin, which will involve creating new operator, we use a long activeDeadlineSeconds as build are
-	// designed to terminate
-	var defActiveDeadline int64
-	defActiveDeadline = 604800 // 1 week = 60 sec * 60 min * 24 hr * 7 days
-	pod.Spec.ActiveDeadlineSeconds = &defActiveDeadline
-	return pod
+	if len(digest) == 0 {
+		return
+	}
+	metav1.SetMetaDataAnnotation(&pod.ObjectMeta, BuilderImageDigestAnnotation, digest)
 }
 
-// setupAdditionalSecrets creates secret volume mounts in the given pod for the given list of secrets
-func setupAdditionalSecrets(pod *corev1.Pod, container *corev1.Container, secrets []buildv1.SecretSpec) {
-	for _, secretSpec := range secrets {
-		mountSecretVolume(pod, container, secretSpec.SecretSource.Name, secretSpec.MountPath, "secret", nil)
-		klog.V(3).Infof("Installed additional secret in %s, in Pod %s/%s", secretSpec.MountPath, pod.Namespace, pod.Name)
+// setupOutputImageLabel sets the given pod label to the repository of the build
+// output image, without its registry, tag or digest.
+func setupOutputImageLabel(pod *corev1.Pod, buildOutput *corev1.ObjectReference, label string) {
+	if len(label) == 0 || buildOutput == nil || buildOutput.Kind != "DockerImage" {
+		return
+	}
+	ref, err := reference.Parse(buildOutput.Name)
+	if err != nil {
+		klog.V(4).Infof("Unable to parse output image %q of pod %s/%s: %v", buildOutput.Name, pod.Namespace, pod.Name, err)
+		return
+	}
+	repository := ref.Name
+	if len(ref.Namespace) > 0 {
+		repository = ref.Namespace + "/" + ref.Name
+	}
+	value := sanitizeLabelValue(repository)
+	if len(value) == 0 {
+		return
 	}
+	if pod.Labels == nil {
+		pod.Labels = map[string]string{}
+	}
+	pod.Labels[label] = value
 }
 
-// getPodLabels creates labels for the Build Pod
-func getPodLabels(build *buildv1.Build) map[string]string {
-	return map[string]string{buildv1.BuildLabel: buildutil.LabelValue(build.Name)}
+// sanitizeLabelValue coerces the given string into a valid label value by replacing
+// invalid characters with dashes and truncating it to the maximum label length.
+func sanitizeLabelValue(value string) string {
+	const trimChars = "-_."
+	value = invalidLabelValueRegex.ReplaceAllString(value, "-")
+	value = strings.Trim(value, trimChars)
+	if len(value) > kvalidation.LabelValueMaxLength {
+		value = strings.TrimRight(value[:kvalidation.LabelValueMaxLength], trimChars)
+	}
+	return value
 }
 
 func makeOwnerReference(build *buildv1.Build) metav1.OwnerReference {
@@ -374,6 +1995,23 @@ func setOwnerReference(pod *corev1.Pod, build *buildv1.Build) {
 	pod.OwnerReferences = []metav1.OwnerReference{makeOwnerReference(build)}
 }
 
+// isDetachedPodRequested returns true if the build asks for its pod to be left
+// without an owner reference.
+func isDetachedPodRequested(build *buildv1.Build) bool {
+	detached, err := strconv.ParseBool(build.Annotations[detachedPodAnnotation])
+	return err == nil && detached
+}
+
+// IsPodOfBuild returns true if the build pod has an OwnerReference to the build, or
+// is a detached pod created for it.
+func IsPodOfBuild(pod *corev1.Pod, build *buildv1.Build) bool {
+	if HasOwnerReference(pod, build) {
+		return true
+	}
+	uid, ok := pod.Annotations[DetachedBuildUIDAnnotation]
+	return ok && len(build.UID) > 0 && uid == string(build.UID)
+}
+
 // HasOwnerReference returns true if the build pod has an OwnerReference to the
 // build.
 func HasOwnerReference(pod *corev1.Pod, build *buildv1.Build) bool {
@@ -512,6 +2150,28 @@ func setupContainersStorage(pod *corev1.Pod, container *corev1.Container) {
 	)
 }
 
+// setupStorageOptions sets the STORAGE_OPTS environment variable of the container to the
+// given containers/storage options, when its storage is backed by the container-storage
+// emptyDir volume. Each option must be of the form key=value.
+func setupStorageOptions(pod *corev1.Pod, container *corev1.Container, options string) error {
+	if len(options) == 0 {
+		return nil
+	}
+	for _, opt := range strings.Split(options, ",") {
+		key, _, ok := strings.Cut(opt, "=")
+		if !ok || len(strings.TrimSpace(key)) == 0 || strings.ContainsAny(opt, " \t\n") {
+			return &FatalError{fmt.Sprintf("invalid storage option %q, expected key=value", opt)}
+		}
+	}
+	for _, v := range pod.Spec.Volumes {
+		if v.Name == "container-storage-root" && v.EmptyDir != nil {
+			container.Env = append(container.Env, corev1.EnvVar{Name: "STORAGE_OPTS", Value: options})
+			return nil
+		}
+	}
+	return nil
+}
+
 func addVolumeMountToContainers(conts []corev1.Container, mount corev1.VolumeMount) []corev1.Container {
 	containers := make([]corev1.Container, len(conts))
 	for i, c := range conts {
@@ -670,6 +2330,29 @@ func setupBuildCAs(build *buildv1.Build, pod *corev1.Pod, additionalCAs map[stri
 	}
 }
 
+// setupNodeCATrust mounts the node's CA trust directory read-only into the container.
+// Builds with a read-only root filesystem must copy the trust from the mount path
+// rather than expecting it under /etc/pki.
+func setupNodeCATrust(pod *corev1.Pod, container *corev1.Container) {
+	const volumeName = "node-ca-trust"
+	hostPathDirectory := corev1.HostPathDirectory
+	pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+		Name: volumeName,
+		VolumeSource: corev1.VolumeSource{
+			HostPath: &corev1.HostPathVolumeSource{
+				Path: "/etc/pki",
+				Type: &hostPathDirectory,
+			},
+		},
+	})
+	container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+		Name:      volumeName,
+		MountPath: NodeCATrustMountPath,
+		ReadOnly:  true,
+	})
+	klog.V(3).Infof("Node CA trust will be mounted in %s in Pod %s/%s", NodeCATrustMountPath, pod.Namespace, pod.Name)
+}
+
 // setupBlobCache configures a shared volume for caching image blobs across the build pod containers.
 func setupBlobCache(pod *corev1.Pod) {
 	const volume = "build-blob-cache"
@@ -32,7 +32,7 @@ func TestSetupDockerSocketHostSocket(t *testing.T) {
 		},
 	}
 
-	setupDockerSocket(&pod)
+	setupDockerSocket(&pod, dockerSocketPath)
 
 	if len(pod.Spec.Volumes) != 1 {
 		t.Fatalf("Expected 1 volume, got: %#v", pod.Spec.Volumes)
@@ -164,6 +164,16 @@ func TestCopyEnvVarSlice(t *testing.T) {
 	}
 }
 
+// findEnvVar returns the environment variable with the given name, if present.
+func findEnvVar(vars []corev1.EnvVar, name string) (corev1.EnvVar, bool) {
+	for _, env := range vars {
+		if env.Name == name {
+			return env, true
+		}
+	}
+	return corev1.EnvVar{}, false
+}
+
 func checkAliasing(t *testing.T, pod *corev1.Pod) {
 	m := map[uintptr]bool{}
 	for _, c := range pod.Spec.Containers {
@@ -918,3 +928,124 @@ func testCreateBuildPodAutonsUser(t *testing.T, build *buildv1.Build, strategy b
 		})
 	}
 }
+
+func TestValidateResolvConfDNSPolicy(t *testing.T) {
+	tests := []struct {
+		name      string
+		policy    corev1.DNSPolicy
+		config    *corev1.PodDNSConfig
+		expectErr bool
+	}{
+		{
+			name:      "none with a DNS config",
+			policy:    corev1.DNSNone,
+			config:    &corev1.PodDNSConfig{Nameservers: []string{"10.0.0.10"}},
+			expectErr: true,
+		},
+		{
+			name:   "none without a DNS config",
+			policy: corev1.DNSNone,
+		},
+		{
+			name:   "cluster first with a DNS config",
+			policy: corev1.DNSClusterFirst,
+			config: &corev1.PodDNSConfig{Searches: []string{"builds.svc"}},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			pod := emptyPod()
+			pod.Spec.DNSPolicy = tc.policy
+			pod.Spec.DNSConfig = tc.config
+			err := validateResolvConfDNSPolicy(&pod)
+			if tc.expectErr != IsFatal(err) {
+				t.Errorf("expected fatal error %v, got %v", tc.expectErr, err)
+			}
+		})
+	}
+}
+
+func TestSanitizeLabelValue(t *testing.T) {
+	tests := map[string]string{
+		"valid-value_1.0":         "valid-value_1.0",
+		"argo/run:1234":           "argo-run-1234",
+		"--leading-and-trailing.": "leading-and-trailing",
+		"":                        "",
+		strings.Repeat("a", 70):   strings.Repeat("a", 63),
+	}
+	for value, expected := range tests {
+		if actual := sanitizeLabelValue(value); actual != expected {
+			t.Errorf("expected %q to be sanitized to %q, got %q", value, expected, actual)
+		}
+	}
+}
+
+func TestSetupSecurityPostureAnnotation(t *testing.T) {
+	yes, no := true, false
+	tests := []struct {
+		name            string
+		securityContext *corev1.SecurityContext
+		volumes         []corev1.Volume
+		expected        string
+	}{
+		{
+			name:     "no security context",
+			expected: "privileged=false,runAsNonRoot=false,readOnlyRootFilesystem=false,dockerSocket=false",
+		},
+		{
+			name: "restricted",
+			securityContext: &corev1.SecurityContext{
+				Privileged:             &no,
+				RunAsNonRoot:           &yes,
+				ReadOnlyRootFilesystem: &yes,
+			},
+			expected: "privileged=false,runAsNonRoot=true,readOnlyRootFilesystem=true,dockerSocket=false",
+		},
+		{
+			name:            "privileged with docker socket",
+			securityContext: &corev1.SecurityContext{Privileged: &yes},
+			volumes:         []corev1.Volume{{Name: "docker-socket"}},
+			expected:        "privileged=true,runAsNonRoot=false,readOnlyRootFilesystem=false,dockerSocket=true",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			pod := emptyPod()
+			pod.Spec.Containers[0].SecurityContext = tc.securityContext
+			pod.Spec.Volumes = tc.volumes
+			setupSecurityPostureAnnotation(&pod)
+			if posture := pod.Annotations[SecurityPostureAnnotation]; posture != tc.expected {
+				t.Errorf("expected security posture %q, got %q", tc.expected, posture)
+			}
+		})
+	}
+}
+
+func TestSetupStorageOptionsWithoutStorageVolume(t *testing.T) {
+	pod := emptyPod()
+	if err := setupStorageOptions(&pod, &pod.Spec.Containers[0], "overlay.mountopt=nodev"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(pod.Spec.Containers[0].Env) != 0 {
+		t.Errorf("expected no STORAGE_OPTS without the container storage volume, got %v", pod.Spec.Containers[0].Env)
+	}
+}
+
+func TestEnvValueFromWarnings(t *testing.T) {
+	fieldRef := &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.name"}}
+	env := []corev1.EnvVar{
+		{Name: "PLAIN", Value: "value"},
+		{Name: "POD_NAME", ValueFrom: fieldRef},
+		{Name: "NODE_NAME", ValueFrom: fieldRef},
+	}
+	encodedEnv := []corev1.EnvVar{
+		{Name: "PLAIN", Value: "value"},
+		{Name: "POD_NAME", ValueFrom: fieldRef},
+		{Name: "NODE_NAME"},
+	}
+	warnings := envValueFromWarnings(env, encodedEnv, "v1")
+	expected := []string{"env NODE_NAME valueFrom cannot be represented in v1"}
+	if !reflect.DeepEqual(expected, warnings) {
+		t.Errorf("expected warnings %v, got %v", expected, warnings)
+	}
+}
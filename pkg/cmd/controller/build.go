@@ -87,7 +87,17 @@ func RunBuildController(ctx *ControllerContext) (bool, error) {
 			SecurityClient:          securityClient.SecurityV1(),
 			BuildCSIVolumeseEnabled: csiVolumesEnabled,
 		},
-		CustomBuildStrategy:      &buildstrategy.CustomBuildStrategy{},
+		// The custom build strategy shares the listers of the build controller, which
+		// waits for their caches to sync. Its other options, such as EnvironmentLabel
+		// which NamespaceLister is only used for, and AnnotateEffectiveNodeSelector are
+		// left unset, as BuildControllerConfig in openshift/api has no fields to
+		// configure them. ImageResolver and ImageMetadataResolver have no
+		// implementation yet, so the checks using them are off as well.
+		CustomBuildStrategy: &buildstrategy.CustomBuildStrategy{
+			ConfigMapLister:      configMapInformer.Lister(),
+			SecretLister:         secretInformer.Lister(),
+			ServiceAccountLister: serviceAccountInformer.Lister(),
+		},
 		BuildDefaults:            builddefaults.BuildDefaults{Config: ctx.OpenshiftControllerConfig.Build.BuildDefaults},
 		BuildOverrides:           buildoverrides.BuildOverrides{Config: ctx.OpenshiftControllerConfig.Build.BuildOverrides},
 		InternalRegistryHostname: ctx.OpenshiftControllerConfig.DockerPullSecret.InternalRegistryHostname,